@@ -0,0 +1,168 @@
+package forest
+
+import (
+	"fmt"
+
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// AncestryValidator validates nodes along with every node that they depend
+// upon. For a Reply, that is every reply between it and the root of its
+// conversation, the Community containing it, and the Identity that authored
+// each of those nodes. Every one of those nodes must pass the same checks as
+// those performed by a ValidatingStore, and each Reply must be consistent with
+// its parent:
+//
+// - its Depth must be exactly one greater than the parent's Depth
+// - its CommunityID must match the parent (or be the parent, if the parent is a Community)
+// - its ConversationID must be the null hash if the parent is a Community, the
+// parent's ID if the parent is the root of a conversation, or the parent's
+// ConversationID otherwise
+//
+// The AncestryValidator remembers every node that it has validated, so
+// validating all nodes within a thread only validates each ancestor once.
+// An AncestryValidator is not safe for concurrent use.
+type AncestryValidator struct {
+	Store     Store
+	validated map[string]struct{}
+}
+
+// NewAncestryValidator creates an AncestryValidator that looks up ancestors
+// within the given store.
+func NewAncestryValidator(store Store) *AncestryValidator {
+	return &AncestryValidator{
+		Store:     store,
+		validated: make(map[string]struct{}),
+	}
+}
+
+// ValidateAncestry validates the node and all of its ancestors within the store.
+// When validating many nodes, use an AncestryValidator instead so that shared
+// ancestors are only validated once.
+func ValidateAncestry(node Node, store Store) error {
+	return NewAncestryValidator(store).Validate(node)
+}
+
+func (a *AncestryValidator) isValidated(id *fields.QualifiedHash) (bool, error) {
+	idString, err := id.MarshalString()
+	if err != nil {
+		return false, err
+	}
+	_, validated := a.validated[idString]
+	return validated, nil
+}
+
+func (a *AncestryValidator) markValidated(id *fields.QualifiedHash) error {
+	idString, err := id.MarshalString()
+	if err != nil {
+		return err
+	}
+	a.validated[idString] = struct{}{}
+	return nil
+}
+
+// get fetches a node that the node with the given ID depends upon.
+func (a *AncestryValidator) get(dependent, id *fields.QualifiedHash) (Node, error) {
+	node, has, err := a.Store.Get(id)
+	if err != nil {
+		return nil, &ValidationError{Check: CheckReferences, ID: dependent, Err: err}
+	} else if !has {
+		return nil, &ValidationError{Check: CheckReferences, ID: dependent, Err: fmt.Errorf("Missing required node %v", id)}
+	}
+	return node, nil
+}
+
+// Validate checks the node and all of its ancestors, returning a
+// *ValidationError describing the first failure encountered.
+func (a *AncestryValidator) Validate(node Node) error {
+	// walk up the tree until reaching a root or a node that is already
+	// known to be valid
+	var chain []Node
+	for current := node; current != nil; {
+		if validated, err := a.isValidated(current.ID()); err != nil {
+			return err
+		} else if validated {
+			break
+		}
+		chain = append(chain, current)
+		parentID := current.ParentID()
+		if parentID.Equals(fields.NullHash()) {
+			break
+		}
+		parent, err := a.get(current.ID(), parentID)
+		if err != nil {
+			return err
+		}
+		current = parent
+	}
+	// validate from the top of the tree downward so that each node's parent
+	// has been validated before the node itself
+	for i := len(chain) - 1; i >= 0; i-- {
+		if err := a.validateOne(chain[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateOne validates a single node whose parent (if any) is already known
+// to be valid.
+func (a *AncestryValidator) validateOne(node Node) error {
+	if signed, ok := node.(SignatureValidator); ok && !signed.IsIdentity() {
+		author, err := a.get(node.ID(), signed.SignatureIdentityHash())
+		if err != nil {
+			return err
+		}
+		if err := a.Validate(author); err != nil {
+			return err
+		}
+	}
+	if err := validateNode(node, a.Store); err != nil {
+		return err
+	}
+	if reply, ok := node.(*Reply); ok {
+		if err := a.validateReplyLinkage(reply); err != nil {
+			return &ValidationError{Check: CheckAncestry, ID: reply.ID(), Err: err}
+		}
+	}
+	return a.markValidated(node.ID())
+}
+
+// validateReplyLinkage ensures that the reply is consistent with its parent.
+func (a *AncestryValidator) validateReplyLinkage(r *Reply) error {
+	parentNode, has, err := a.Store.Get(&r.Parent)
+	if err != nil {
+		return err
+	} else if !has {
+		return fmt.Errorf("Missing parent %v", r.Parent)
+	}
+	switch parent := parentNode.(type) {
+	case *Community:
+		if r.Depth != parent.Depth+1 {
+			return fmt.Errorf("Reply depth %d is inconsistent with parent depth %d", r.Depth, parent.Depth)
+		}
+		if !r.CommunityID.Equals(parent.ID()) {
+			return fmt.Errorf("Reply community %v does not match parent community %v", r.CommunityID, parent.ID())
+		}
+		if !r.ConversationID.Equals(fields.NullHash()) {
+			return fmt.Errorf("Reply to a community must have the null hash as its conversation")
+		}
+	case *Reply:
+		if r.Depth != parent.Depth+1 {
+			return fmt.Errorf("Reply depth %d is inconsistent with parent depth %d", r.Depth, parent.Depth)
+		}
+		if !r.CommunityID.Equals(&parent.CommunityID) {
+			return fmt.Errorf("Reply community %v does not match parent community %v", r.CommunityID, parent.CommunityID)
+		}
+		expectedConversation := &parent.ConversationID
+		if parent.ConversationID.Equals(fields.NullHash()) {
+			expectedConversation = parent.ID()
+		}
+		if !r.ConversationID.Equals(expectedConversation) {
+			return fmt.Errorf("Reply conversation %v does not match parent conversation %v", r.ConversationID, expectedConversation)
+		}
+	default:
+		return fmt.Errorf("Reply parent must be a community or reply, got %T", parentNode)
+	}
+	return nil
+}
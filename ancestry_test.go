@@ -0,0 +1,119 @@
+package forest_test
+
+import (
+	"testing"
+
+	forest "git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// countingStore records the number of calls to Get
+type countingStore struct {
+	*forest.MemoryStore
+	gets int
+}
+
+func (c *countingStore) Get(id *fields.QualifiedHash) (forest.Node, bool, error) {
+	c.gets++
+	return c.MemoryStore.Get(id)
+}
+
+// resignReplyOrSkip signs the reply again after it has been modified and
+// returns a copy of it with a recomputed ID.
+func resignReplyOrSkip(t *testing.T, r *forest.Reply, signer forest.Signer) *forest.Reply {
+	data, err := r.MarshalSignedData()
+	if err != nil {
+		t.Skip("Failed to marshal signed data", err)
+	}
+	signature, err := signer.Sign(data)
+	if err != nil {
+		t.Skip("Failed to sign data", err)
+	}
+	qs, err := fields.NewQualifiedSignature(fields.SignatureTypeOpenPGP, signature)
+	if err != nil {
+		t.Skip("Failed to qualify signature", err)
+	}
+	r.Signature = *qs
+	b, err := r.MarshalBinary()
+	if err != nil {
+		t.Skip("Failed to marshal reply", err)
+	}
+	resigned, err := forest.UnmarshalReply(b)
+	if err != nil {
+		t.Skip("Failed to unmarshal reply", err)
+	}
+	return resigned
+}
+
+func makeThreadOrSkip(t *testing.T, store forest.Store, depth int) (forest.Signer, []*forest.Reply) {
+	identity, signer, community, reply := MakeValidReplyOrSkip(t)
+	thread := []*forest.Reply{reply}
+	for len(thread) < depth {
+		thread = append(thread, newReplyOrSkip(t, forest.As(identity, signer), thread[len(thread)-1], "deeper"))
+	}
+	for _, node := range []forest.Node{identity, community} {
+		if err := store.Add(node); err != nil {
+			t.Skip("Failed to add node", err)
+		}
+	}
+	for _, node := range thread {
+		if err := store.Add(node); err != nil {
+			t.Skip("Failed to add node", err)
+		}
+	}
+	return signer, thread
+}
+
+func TestValidateAncestry(t *testing.T) {
+	store := forest.NewMemoryStore()
+	_, thread := makeThreadOrSkip(t, store, 4)
+	if err := forest.ValidateAncestry(thread[len(thread)-1], store); err != nil {
+		t.Errorf("Valid thread should pass ancestry validation, got %v", err)
+	}
+}
+
+func TestValidateAncestryTamperedAncestor(t *testing.T) {
+	store := forest.NewMemoryStore()
+	_, thread := makeThreadOrSkip(t, store, 4)
+	thread[1].Content.Blob = fields.Blob([]byte("DEEPER"))
+	expectValidationFailure(t, forest.ValidateAncestry(thread[len(thread)-1], store), forest.CheckID)
+}
+
+func TestValidateAncestryMissingAncestor(t *testing.T) {
+	full := forest.NewMemoryStore()
+	_, thread := makeThreadOrSkip(t, full, 3)
+	partial := forest.NewMemoryStore()
+	for _, node := range []forest.Node{thread[0], thread[2]} {
+		if err := partial.Add(node); err != nil {
+			t.Skip("Failed to add node", err)
+		}
+	}
+	expectValidationFailure(t, forest.ValidateAncestry(thread[2], partial), forest.CheckReferences)
+}
+
+func TestValidateAncestryInconsistentLinkage(t *testing.T) {
+	store := forest.NewMemoryStore()
+	signer, thread := makeThreadOrSkip(t, store, 3)
+	leaf := thread[2]
+	leaf.ConversationID = *thread[1].ID()
+	forged := resignReplyOrSkip(t, leaf, signer)
+	expectValidationFailure(t, forest.ValidateAncestry(forged, store), forest.CheckAncestry)
+}
+
+func TestAncestryValidatorMemoizes(t *testing.T) {
+	store := &countingStore{MemoryStore: forest.NewMemoryStore()}
+	_, thread := makeThreadOrSkip(t, store, 6)
+	validator := forest.NewAncestryValidator(store)
+	if err := validator.Validate(thread[4]); err != nil {
+		t.Fatalf("Valid thread should pass ancestry validation, got %v", err)
+	}
+	store.gets = 0
+	if err := validator.Validate(thread[5]); err != nil {
+		t.Fatalf("Valid thread should pass ancestry validation, got %v", err)
+	}
+	// the leaf requires a bounded number of lookups for its own references,
+	// regardless of the depth of the thread
+	if store.gets > 8 {
+		t.Errorf("Validating a node with validated ancestors should not revisit them, performed %d lookups", store.gets)
+	}
+}
@@ -0,0 +1,425 @@
+package forest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// MaxChunkSize is the maximum number of bytes of data within a Chunk
+const MaxChunkSize = fields.MaxContentLength
+
+// Chunk nodes hold part of the data of an Attachment. The chunks of an
+// Attachment form a list, with each Chunk holding the ID of the Chunk that
+// follows it, or the null hash if it is the last. Because the ID of each
+// Chunk covers the ID of the next, the ID of the first Chunk identifies all of
+// the data, like the root of a Merkle tree. Like an Edit, a Chunk has no
+// parent and a depth of zero.
+type Chunk struct {
+	commonNode
+	Next fields.QualifiedHash
+	Data fields.QualifiedContent
+}
+
+func newChunk() *Chunk {
+	c := new(Chunk)
+	// define how to serialize this node type's fields
+	return c
+}
+
+func (c *Chunk) nodeSpecificSerializationOrder() []fields.BidirectionalBinaryMarshaler {
+	return []fields.BidirectionalBinaryMarshaler{&c.Next, &c.Data}
+}
+
+func (c *Chunk) SerializationOrder() []fields.BidirectionalBinaryMarshaler {
+	order := c.commonNode.presignSerializationOrder()
+	order = append(order, c.nodeSpecificSerializationOrder()...)
+	order = append(order, c.commonNode.postsignSerializationOrder()...)
+	return order
+}
+
+func (c Chunk) MarshalSignedData() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(c.presignSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(c.nodeSpecificSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c Chunk) MarshalBinary() ([]byte, error) {
+	signed, err := c.MarshalSignedData()
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(signed)
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(c.postsignSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func UnmarshalChunk(b []byte) (*Chunk, error) {
+	c := newChunk()
+	if err := c.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Chunk) UnmarshalBinary(b []byte) error {
+	if err := c.unmarshalBinaryVersion(b); err != nil {
+		return err
+	}
+	if err := UnmarshalBinary(c, b); err != nil {
+		return err
+	}
+	idBytes, err := computeID(c)
+	if err != nil {
+		return err
+	}
+	c.id = fields.Blob(idBytes)
+	return nil
+}
+
+func (c *Chunk) Equals(other interface{}) bool {
+	c2, valid := other.(*Chunk)
+	if !valid {
+		return false
+	}
+	return c.commonNode.Equals(&c2.commonNode) &&
+		c.Next.Equals(&c2.Next) &&
+		c.Data.Equals(&c2.Data)
+}
+
+// ValidateShallow checks all fields for internal validity. It does not check
+// the existence or validity of nodes referenced from this node.
+func (c *Chunk) ValidateShallow() error {
+	if err := c.commonNode.ValidateShallow(); err != nil {
+		return err
+	}
+	needsValidation := []Validator{&c.Next, &c.Data}
+	for _, nv := range needsValidation {
+		if err := nv.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Data.Descriptor.Type != fields.ContentTypeBinary {
+		return fmt.Errorf("Chunk data must be binary, got content type %d", c.Data.Descriptor.Type)
+	}
+	if len(c.Data.Blob) == 0 {
+		return fmt.Errorf("Chunk data must not be empty")
+	}
+	if !c.Parent.Equals(fields.NullHash()) {
+		return fmt.Errorf("Chunk parent must be null hash, got %v", c.Parent)
+	}
+	if c.Depth != 0 {
+		return fmt.Errorf("Chunk depth must be 0, got %d", c.Depth)
+	}
+	if c.Author.Equals(fields.NullHash()) {
+		return fmt.Errorf("Chunk author must not be null hash")
+	}
+	return nil
+}
+
+// ValidateDeep checks that the author and the next Chunk, if any, exist
+// within the store, and that the next Chunk has the same author.
+func (c *Chunk) ValidateDeep(store Store) error {
+	if _, has, err := store.Get(&c.Author); !has {
+		return fmt.Errorf("Missing required node %v", c.Author)
+	} else if err != nil {
+		return err
+	}
+	if c.Next.Equals(fields.NullHash()) {
+		return nil
+	}
+	_, err := getChunk(store, &c.Next, &c.Author)
+	return err
+}
+
+// getChunk looks up the Chunk with the given ID, ensuring that it was written
+// by the given author.
+func getChunk(store Store, id, author *fields.QualifiedHash) (*Chunk, error) {
+	node, has, err := store.Get(id)
+	if !has {
+		return nil, fmt.Errorf("Missing required node %v", id)
+	} else if err != nil {
+		return nil, err
+	}
+	chunk, ok := node.(*Chunk)
+	if !ok {
+		return nil, fmt.Errorf("Expected chunk %v, got %T", id, node)
+	}
+	if !chunk.Author.Equals(author) {
+		return nil, fmt.Errorf("Chunk %v was written by a different author", id)
+	}
+	return chunk, nil
+}
+
+// Attachment nodes describe a file, like an image, whose data is held by a
+// list of Chunks. The MIMEType describes the format of the data, Head is the
+// ID of the first Chunk, and Size is the total number of bytes of data. Every
+// Chunk must be written by the author of the Attachment. Like an Edit, an
+// Attachment has no parent and a depth of zero, so it is shared by referring
+// to its ID, for instance within the metadata of a Reply. Use
+// Builder.NewAttachment to create an Attachment and ReassembleAttachment to
+// read its data.
+type Attachment struct {
+	commonNode
+	MIMEType fields.QualifiedContent
+	Head     fields.QualifiedHash
+	Size     fields.DataSize
+}
+
+func newAttachment() *Attachment {
+	a := new(Attachment)
+	// define how to serialize this node type's fields
+	return a
+}
+
+func (a *Attachment) nodeSpecificSerializationOrder() []fields.BidirectionalBinaryMarshaler {
+	return []fields.BidirectionalBinaryMarshaler{&a.MIMEType, &a.Head, &a.Size}
+}
+
+func (a *Attachment) SerializationOrder() []fields.BidirectionalBinaryMarshaler {
+	order := a.commonNode.presignSerializationOrder()
+	order = append(order, a.nodeSpecificSerializationOrder()...)
+	order = append(order, a.commonNode.postsignSerializationOrder()...)
+	return order
+}
+
+func (a Attachment) MarshalSignedData() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(a.presignSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(a.nodeSpecificSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (a Attachment) MarshalBinary() ([]byte, error) {
+	signed, err := a.MarshalSignedData()
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(signed)
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(a.postsignSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func UnmarshalAttachment(b []byte) (*Attachment, error) {
+	a := newAttachment()
+	if err := a.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *Attachment) UnmarshalBinary(b []byte) error {
+	if err := a.unmarshalBinaryVersion(b); err != nil {
+		return err
+	}
+	if err := UnmarshalBinary(a, b); err != nil {
+		return err
+	}
+	idBytes, err := computeID(a)
+	if err != nil {
+		return err
+	}
+	a.id = fields.Blob(idBytes)
+	return nil
+}
+
+func (a *Attachment) Equals(other interface{}) bool {
+	a2, valid := other.(*Attachment)
+	if !valid {
+		return false
+	}
+	return a.commonNode.Equals(&a2.commonNode) &&
+		a.MIMEType.Equals(&a2.MIMEType) &&
+		a.Head.Equals(&a2.Head) &&
+		a.Size.Equals(&a2.Size)
+}
+
+// validateMIMEType ensures that the MIME type is UTF-8 text in the form
+// accepted by mime.ParseMediaType.
+func validateMIMEType(mimeType *fields.QualifiedContent) error {
+	if mimeType.Descriptor.Type != fields.ContentTypeUTF8String {
+		return fmt.Errorf("MIME type must be UTF-8, got content type %d", mimeType.Descriptor.Type)
+	}
+	if _, _, err := mime.ParseMediaType(string(mimeType.Blob)); err != nil {
+		return fmt.Errorf("Invalid MIME type %q: %v", mimeType.Blob, err)
+	}
+	return nil
+}
+
+// ValidateShallow checks all fields for internal validity. It does not check
+// the existence or validity of nodes referenced from this node.
+func (a *Attachment) ValidateShallow() error {
+	if err := a.commonNode.ValidateShallow(); err != nil {
+		return err
+	}
+	needsValidation := []Validator{&a.MIMEType, &a.Head}
+	for _, nv := range needsValidation {
+		if err := nv.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := validateMIMEType(&a.MIMEType); err != nil {
+		return err
+	}
+	if !a.Parent.Equals(fields.NullHash()) {
+		return fmt.Errorf("Attachment parent must be null hash, got %v", a.Parent)
+	}
+	if a.Depth != 0 {
+		return fmt.Errorf("Attachment depth must be 0, got %d", a.Depth)
+	}
+	if a.Head.Equals(fields.NullHash()) {
+		return fmt.Errorf("Attachment head must not be null hash")
+	}
+	if a.Size == 0 {
+		return fmt.Errorf("Attachment size must not be zero")
+	}
+	if a.Author.Equals(fields.NullHash()) {
+		return fmt.Errorf("Attachment author must not be null hash")
+	}
+	return nil
+}
+
+// ValidateDeep checks that the author and the first Chunk exist within the
+// store, and that the first Chunk has the same author. Each Chunk ensures
+// that the next exists when it is validated, so a ValidatingStore only
+// accepts an Attachment once all of its Chunks are present.
+func (a *Attachment) ValidateDeep(store Store) error {
+	if _, has, err := store.Get(&a.Author); !has {
+		return fmt.Errorf("Missing required node %v", a.Author)
+	} else if err != nil {
+		return err
+	}
+	_, err := getChunk(store, &a.Head, &a.Author)
+	return err
+}
+
+type chunkJSON struct {
+	nodeJSON
+	Next *fields.QualifiedHash    `json:"next"`
+	Data *fields.QualifiedContent `json:"data"`
+}
+
+// MarshalJSON converts the Chunk into its canonical JSON form.
+func (c *Chunk) MarshalJSON() ([]byte, error) {
+	return json.Marshal(chunkJSON{
+		nodeJSON: c.commonNode.toJSON(),
+		Next:     &c.Next,
+		Data:     &c.Data,
+	})
+}
+
+// UnmarshalJSON populates the Chunk from its canonical JSON form. It fails
+// unless the ID within the JSON matches the Chunk's contents. Its signature
+// cannot be checked without its author, so use UnmarshalJSONNode to decode
+// untrusted nodes.
+func (c *Chunk) UnmarshalJSON(b []byte) error {
+	var in chunkJSON
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	if err := requireJSONFields([]jsonField{
+		{"next", in.Next != nil},
+		{"data", in.Data != nil},
+	}); err != nil {
+		return err
+	}
+	if err := c.commonNode.fromJSON(&in.nodeJSON, fields.NodeTypeChunk); err != nil {
+		return err
+	}
+	c.Next = *in.Next
+	c.Data = *in.Data
+	return finishJSON(c, &c.commonNode, in.ID)
+}
+
+type attachmentJSON struct {
+	nodeJSON
+	MIMEType *fields.QualifiedContent `json:"mimeType"`
+	Head     *fields.QualifiedHash    `json:"head"`
+	Size     *uint64                  `json:"size"`
+}
+
+// MarshalJSON converts the Attachment into its canonical JSON form.
+func (a *Attachment) MarshalJSON() ([]byte, error) {
+	return json.Marshal(attachmentJSON{
+		nodeJSON: a.commonNode.toJSON(),
+		MIMEType: &a.MIMEType,
+		Head:     &a.Head,
+		Size:     (*uint64)(&a.Size),
+	})
+}
+
+// UnmarshalJSON populates the Attachment from its canonical JSON form. It
+// fails unless the ID within the JSON matches the Attachment's contents. Its
+// signature cannot be checked without its author, so use UnmarshalJSONNode to
+// decode untrusted nodes.
+func (a *Attachment) UnmarshalJSON(b []byte) error {
+	var in attachmentJSON
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	if err := requireJSONFields([]jsonField{
+		{"mimeType", in.MIMEType != nil},
+		{"head", in.Head != nil},
+		{"size", in.Size != nil},
+	}); err != nil {
+		return err
+	}
+	if err := a.commonNode.fromJSON(&in.nodeJSON, fields.NodeTypeAttachment); err != nil {
+		return err
+	}
+	a.MIMEType = *in.MIMEType
+	a.Head = *in.Head
+	a.Size = fields.DataSize(*in.Size)
+	return finishJSON(a, &a.commonNode, in.ID)
+}
+
+// ReassembleAttachment writes the data of the attachment to w, following its
+// Chunks from the first to the last. Each Chunk is looked up within the store
+// and its contents must match the ID that refers to it, so the data cannot be
+// altered by the store. It returns an error if the Chunks do not hold exactly
+// the Size of the attachment, and the number of bytes written.
+func ReassembleAttachment(store Store, attachment *Attachment, w io.Writer) (int64, error) {
+	var written, size int64 = 0, int64(attachment.Size)
+	next := &attachment.Head
+	for !next.Equals(fields.NullHash()) {
+		chunk, err := getChunk(store, next, &attachment.Author)
+		if err != nil {
+			return written, err
+		}
+		if valid, err := ValidateID(chunk, *next); err != nil {
+			return written, err
+		} else if !valid {
+			return written, fmt.Errorf("Chunk contents do not match ID %v", next)
+		}
+		if int64(len(chunk.Data.Blob)) > size-written {
+			return written, fmt.Errorf("Attachment data is longer than its size of %d bytes", size)
+		}
+		n, err := w.Write(chunk.Data.Blob)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		next = &chunk.Next
+	}
+	if written != size {
+		return written, fmt.Errorf("Attachment data is truncated, got %d of %d bytes", written, size)
+	}
+	return written, nil
+}
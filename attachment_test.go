@@ -0,0 +1,118 @@
+package forest_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	forest "git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+func newAttachmentOrSkip(t *testing.T, builder *forest.Builder, data []byte) (*forest.Attachment, []*forest.Chunk) {
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	attachment, chunks, err := builder.NewAttachment(bytes.NewReader(data), "application/octet-stream", metadata)
+	if err != nil {
+		t.Skip("Failed to create attachment", err)
+	}
+	return attachment, chunks
+}
+
+func randomBytesOrSkip(t *testing.T, size int) []byte {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Skip("Failed to generate random data", err)
+	}
+	return data
+}
+
+func TestAttachmentReassembly(t *testing.T) {
+	identity, signer := makeEd25519IdentityOrSkip(t)
+	data := randomBytesOrSkip(t, 2*forest.MaxChunkSize+100)
+	attachment, chunks := newAttachmentOrSkip(t, forest.As(identity, signer), data)
+	if len(chunks) != 3 {
+		t.Errorf("Expected 3 chunks, got %d", len(chunks))
+	}
+	if attachment.Size != fields.DataSize(len(data)) {
+		t.Errorf("Expected attachment size %d, got %d", len(data), attachment.Size)
+	}
+	s := forest.NewValidatingStore(forest.NewMemoryStore())
+	if err := s.Add(identity); err != nil {
+		t.Skip("Failed to add identity", err)
+	}
+	expectValidationFailure(t, s.Add(attachment), forest.CheckReferences)
+	for _, chunk := range chunks {
+		if err := s.Add(chunk); err != nil {
+			t.Fatalf("Store should accept valid chunk, got %v", err)
+		}
+	}
+	if err := s.Add(attachment); err != nil {
+		t.Fatalf("Store should accept valid attachment, got %v", err)
+	}
+	out := new(bytes.Buffer)
+	if n, err := forest.ReassembleAttachment(s, attachment, out); err != nil {
+		t.Fatalf("Failed to reassemble attachment: %v", err)
+	} else if n != int64(len(data)) || !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("Reassembled attachment does not match the original data")
+	}
+}
+
+func TestAttachmentReassemblyVerifiesChunks(t *testing.T) {
+	identity, signer := makeEd25519IdentityOrSkip(t)
+	attachment, chunks := newAttachmentOrSkip(t, forest.As(identity, signer), randomBytesOrSkip(t, forest.MaxChunkSize+1))
+	s := forest.NewMemoryStore()
+	// replace the data of the last chunk while keeping its ID
+	altered := *chunks[0]
+	altered.Data = *QualifiedContentOrSkip(t, fields.ContentTypeBinary, []byte("altered"))
+	for _, node := range []forest.Node{identity, &altered, chunks[1], attachment} {
+		if err := s.Add(node); err != nil {
+			t.Skip("Failed to add node", err)
+		}
+	}
+	if _, err := forest.ReassembleAttachment(s, attachment, new(bytes.Buffer)); err == nil {
+		t.Errorf("Reassembly should fail when a chunk does not match its ID")
+	}
+}
+
+func TestAttachmentReassemblyChecksSize(t *testing.T) {
+	identity, signer := makeEd25519IdentityOrSkip(t)
+	attachment, chunks := newAttachmentOrSkip(t, forest.As(identity, signer), randomBytesOrSkip(t, forest.MaxChunkSize+1))
+	s := forest.NewMemoryStore()
+	for _, node := range []forest.Node{identity, chunks[0], chunks[1], attachment} {
+		if err := s.Add(node); err != nil {
+			t.Skip("Failed to add node", err)
+		}
+	}
+	for _, size := range []fields.DataSize{attachment.Size - 1, attachment.Size + 1} {
+		altered := *attachment
+		altered.Size = size
+		if _, err := forest.ReassembleAttachment(s, &altered, new(bytes.Buffer)); err == nil {
+			t.Errorf("Reassembly should fail when the chunks hold %d bytes rather than %d", attachment.Size, size)
+		}
+	}
+}
+
+func TestNewAttachmentValidation(t *testing.T) {
+	identity, signer := makeEd25519IdentityOrSkip(t)
+	builder := forest.As(identity, signer)
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	if _, _, err := builder.NewAttachment(bytes.NewReader(nil), "text/plain", metadata); err == nil {
+		t.Errorf("Empty attachment should be invalid")
+	}
+	if _, _, err := builder.NewAttachment(bytes.NewReader([]byte("data")), "not a MIME type", metadata); err == nil {
+		t.Errorf("Attachment with invalid MIME type should be invalid")
+	}
+}
+
+func TestAttachmentRoundTrip(t *testing.T) {
+	identity, signer := makeEd25519IdentityOrSkip(t)
+	attachment, chunks := newAttachmentOrSkip(t, forest.As(identity, signer), []byte("small file"))
+	s := forest.NewValidatingStore(forest.NewMemoryStore())
+	nodes := []forest.Node{identity, chunks[0], attachment}
+	for _, node := range nodes {
+		if err := s.Add(node); err != nil {
+			t.Fatalf("Store should accept valid node, got %v", err)
+		}
+	}
+	assertRoundTrips(t, s, nodes[1:]...)
+}
@@ -2,9 +2,12 @@ package forest
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os/exec"
+	"time"
 
 	"git.sr.ht/~whereswaldon/forest-go/fields"
 	"golang.org/x/crypto/openpgp"
@@ -16,6 +19,15 @@ type Signer interface {
 	PublicKey() (key []byte, err error)
 }
 
+// TypedSigner is a Signer that can report the types of key and signature
+// that it uses. Signers that do not implement TypedSigner are assumed to use
+// OpenPGP keys and signatures.
+type TypedSigner interface {
+	Signer
+	KeyType() fields.KeyType
+	SignatureType() fields.SignatureType
+}
+
 // NativeSigner uses golang's native openpgp operation for signing data. It
 // only supports private keys without a passphrase.
 type NativeSigner openpgp.Entity
@@ -48,6 +60,48 @@ func (s NativeSigner) PublicKey() ([]byte, error) {
 	return keybuf.Bytes(), nil
 }
 
+// KeyType returns the type of the public key used by this signer.
+func (s NativeSigner) KeyType() fields.KeyType {
+	return fields.KeyTypeOpenPGP
+}
+
+// SignatureType returns the type of the signatures produced by this signer.
+func (s NativeSigner) SignatureType() fields.SignatureType {
+	return fields.SignatureTypeOpenPGP
+}
+
+// Ed25519Signer signs data with an Ed25519 private key. Its public key is the
+// raw 32-byte Ed25519 public key.
+type Ed25519Signer ed25519.PrivateKey
+
+// NewEd25519Signer creates a signer from the given Ed25519 private key.
+func NewEd25519Signer(privatekey ed25519.PrivateKey) (Signer, error) {
+	if len(privatekey) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("Ed25519 private key must be %d bytes, got %d", ed25519.PrivateKeySize, len(privatekey))
+	}
+	return Ed25519Signer(privatekey), nil
+}
+
+// Sign signs the input data with the contained private key and returns the resulting signature.
+func (s Ed25519Signer) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(ed25519.PrivateKey(s), data), nil
+}
+
+// PublicKey returns the raw bytes of the Ed25519 public key used by this signer.
+func (s Ed25519Signer) PublicKey() ([]byte, error) {
+	return []byte(ed25519.PrivateKey(s).Public().(ed25519.PublicKey)), nil
+}
+
+// KeyType returns the type of the public key used by this signer.
+func (s Ed25519Signer) KeyType() fields.KeyType {
+	return fields.KeyTypeEd25519
+}
+
+// SignatureType returns the type of the signatures produced by this signer.
+func (s Ed25519Signer) SignatureType() fields.SignatureType {
+	return fields.SignatureTypeEd25519
+}
+
 // GPGSigner uses a local gpg2 installation for key management. It will invoke gpg2 as a subprocess
 // to sign data and to acquire the public key for its signing key. The public fields can be used
 // to modify its behavior in order to change how it prompts for passphrases and other details.
@@ -121,26 +175,69 @@ func (s GPGSigner) PublicKey() ([]byte, error) {
 	return pubkey, nil
 }
 
+// KeyType returns the type of the public key used by this signer.
+func (s GPGSigner) KeyType() fields.KeyType {
+	return fields.KeyTypeOpenPGP
+}
+
+// SignatureType returns the type of the signatures produced by this signer.
+func (s GPGSigner) SignatureType() fields.SignatureType {
+	return fields.SignatureTypeOpenPGP
+}
+
+// signerTypes returns the key and signature types used by the signer.
+func signerTypes(signer Signer) (fields.KeyType, fields.SignatureType) {
+	if typed, ok := signer.(TypedSigner); ok {
+		return typed.KeyType(), typed.SignatureType()
+	}
+	return fields.KeyTypeOpenPGP, fields.SignatureTypeOpenPGP
+}
+
+// signData signs the data and qualifies the resulting signature with the
+// signature type of the signer.
+func signData(signer Signer, data []byte) (*fields.QualifiedSignature, error) {
+	signature, err := signer.Sign(data)
+	if err != nil {
+		return nil, err
+	}
+	_, signatureType := signerTypes(signer)
+	return fields.NewQualifiedSignature(signatureType, signature)
+}
+
 // NewIdentity builds an Identity node for the user with the given name and metadata, using
-// the OpenPGP Entity privkey to define the Identity. That Entity must contain a
-// private key with no passphrase.
+// the public key of the signer to define the Identity. The type of the key is
+// determined by the signer (see TypedSigner).
 func NewIdentity(signer Signer, name *fields.QualifiedContent, metadata *fields.QualifiedContent) (*Identity, error) {
+	return NewIdentityVersion(signer, name, metadata, fields.CurrentVersion)
+}
+
+// NewIdentityVersion builds an Identity node like NewIdentity, but writes it
+// with the given schema version (see SupportedVersions).
+func NewIdentityVersion(signer Signer, name *fields.QualifiedContent, metadata *fields.QualifiedContent, version fields.Version) (*Identity, error) {
+	schema, err := schemaFor(version)
+	if err != nil {
+		return nil, err
+	}
 	// make an empty identity and populate all fields that need to be known before
 	// signing the data
 	identity := newIdentity()
-	identity.SchemaVersion = fields.CurrentVersion
+	identity.SchemaVersion = version
 	identity.Type = fields.NodeTypeIdentity
 	identity.Parent = *fields.NullHash()
 	identity.Depth = 0
 	identity.Name = *name
 	identity.Metadata = *metadata
+	if schema.timestamps {
+		identity.Created = fields.TimestampFrom(time.Now())
+	}
 
 	// get public key
 	pubkey, err := signer.PublicKey()
 	if err != nil {
 		return nil, err
 	}
-	qKey, err := fields.NewQualifiedKey(fields.KeyTypeOpenPGP, pubkey)
+	keyType, _ := signerTypes(signer)
+	qKey, err := fields.NewQualifiedKey(keyType, pubkey)
 	if err != nil {
 		return nil, err
 	}
@@ -151,30 +248,9 @@ func NewIdentity(signer Signer, name *fields.QualifiedContent, metadata *fields.
 		return nil, err
 	}
 	identity.IDDesc = *idDesc
-
-	// we've defined all pre-signature fields, it's time to sign the data
-	signedDataBytes, err := identity.MarshalSignedData()
-	if err != nil {
-		return nil, err
-	}
-	signature, err := signer.Sign(signedDataBytes)
-	if err != nil {
-		return nil, err
-	}
-
-	qs, err := fields.NewQualifiedSignature(fields.SignatureTypeOpenPGP, signature)
-	if err != nil {
+	if err := signNode(signer, identity, &identity.commonNode); err != nil {
 		return nil, err
 	}
-	identity.commonNode.Signature = *qs
-
-	// determine the node's final hash ID
-	id, err := computeID(identity)
-	if err != nil {
-		return nil, err
-	}
-	identity.id = fields.Blob(id)
-
 	return identity, nil
 }
 
@@ -182,6 +258,19 @@ func NewIdentity(signer Signer, name *fields.QualifiedContent, metadata *fields.
 type Builder struct {
 	User *Identity
 	Signer
+	// IDDesc describes the hash algorithm used to compute the IDs of new
+	// nodes. If it is nil, SHA512/256 is used.
+	IDDesc *fields.HashDescriptor
+	// Now returns the creation time of new nodes. If it is nil, time.Now
+	// is used.
+	Now func() time.Time
+	// SchemaVersion is the version of new nodes. If it is zero,
+	// fields.CurrentVersion is used.
+	SchemaVersion fields.Version
+	// Recipients are the key histories of the identities that the content of
+	// new replies and edits is encrypted to. If it is empty, content is not
+	// encrypted.
+	Recipients []*KeyHistory
 }
 
 // As creates a Builder that can write new nodes on behalf of the provided user.
@@ -195,51 +284,95 @@ func As(user *Identity, signer Signer) *Builder {
 	}
 }
 
+// WithIDHash configures the Builder to compute the IDs of new nodes with the
+// given hash algorithm and digest length. It returns the Builder so that it
+// can be used fluently, like:
+//
+// community, err := forest.As(user, privkey).WithIDHash(fields.HashTypeSHA3, fields.HashDigestLengthSHA3_256).NewCommunity(name, metadata)
+func (n *Builder) WithIDHash(t fields.HashType, length fields.ContentLength) *Builder {
+	n.IDDesc = &fields.HashDescriptor{Type: t, Length: length}
+	return n
+}
+
+// EncryptTo configures the Builder to encrypt the content of new replies and
+// edits so that only the given recipients can read it (see EncryptContent and
+// LoadKeyHistory). The Builder's user must be among the recipients in order to
+// read its own replies. It returns the Builder so that it can be used
+// fluently, like:
+//
+// reply, err := forest.As(user, privkey).EncryptTo(userKeys, friendKeys).NewReply(parent, content, metadata)
+func (n *Builder) EncryptTo(recipients ...*KeyHistory) *Builder {
+	n.Recipients = recipients
+	return n
+}
+
+// encrypt encrypts the content to the Builder's recipients, if there are any.
+func (n *Builder) encrypt(content *fields.QualifiedContent) (*fields.QualifiedContent, error) {
+	if len(n.Recipients) == 0 {
+		return content, nil
+	}
+	return EncryptContent(content, n.Recipients)
+}
+
+// prepare sets the schema version and creation time of a new node.
+func (n *Builder) prepare(node *commonNode) error {
+	node.SchemaVersion = n.SchemaVersion
+	if node.SchemaVersion == 0 {
+		node.SchemaVersion = fields.CurrentVersion
+	}
+	schema, err := schemaFor(node.SchemaVersion)
+	if err != nil {
+		return err
+	}
+	if !schema.timestamps {
+		return nil
+	}
+	if n.Now == nil {
+		node.Created = fields.TimestampFrom(time.Now())
+	} else {
+		node.Created = fields.TimestampFrom(n.Now())
+	}
+	return nil
+}
+
+// idDescriptor returns the descriptor for the IDs of new nodes.
+func (n *Builder) idDescriptor() (*fields.HashDescriptor, error) {
+	if n.IDDesc == nil {
+		return fields.NewHashDescriptor(fields.HashTypeSHA512, int(fields.HashDigestLengthSHA512_256))
+	}
+	if n.IDDesc.Type == fields.HashTypeNullHash {
+		return nil, fmt.Errorf("Node IDs cannot use the null hash")
+	}
+	if err := n.IDDesc.Validate(); err != nil {
+		return nil, err
+	}
+	return n.IDDesc, nil
+}
+
 // NewCommunity creates a community node (signed by the given identity with the given privkey).
 func (n *Builder) NewCommunity(name *fields.QualifiedContent, metadata *fields.QualifiedContent) (*Community, error) {
 	c := newCommunity()
-	c.SchemaVersion = fields.CurrentVersion
+	if err := n.prepare(&c.commonNode); err != nil {
+		return nil, err
+	}
 	c.Type = fields.NodeTypeCommunity
 	c.Parent = *fields.NullHash()
 	c.Depth = 0
 	c.Name = *name
 	c.Metadata = *metadata
 	c.Author = *n.User.ID()
-	idDesc, err := fields.NewHashDescriptor(fields.HashTypeSHA512, int(fields.HashDigestLengthSHA512_256))
-	if err != nil {
+	if err := n.finish(c, &c.commonNode); err != nil {
 		return nil, err
 	}
-	c.IDDesc = *idDesc
-
-	// we've defined all pre-signature fields, it's time to sign the data
-	signedDataBytes, err := c.MarshalSignedData()
-	if err != nil {
-		return nil, err
-	}
-	signature, err := n.Sign(signedDataBytes)
-	if err != nil {
-		return nil, err
-	}
-	qs, err := fields.NewQualifiedSignature(fields.SignatureTypeOpenPGP, signature)
-	if err != nil {
-		return nil, err
-	}
-	c.commonNode.Signature = *qs
-
-	// determine the node's final hash ID
-	id, err := computeID(c)
-	if err != nil {
-		return nil, err
-	}
-	c.id = fields.Blob(id)
-
 	return c, nil
 }
 
 // NewReply creates a reply node as a child of the given community or reply
 func (n *Builder) NewReply(parent interface{}, content *fields.QualifiedContent, metadata *fields.QualifiedContent) (*Reply, error) {
 	r := newReply()
-	r.SchemaVersion = fields.CurrentVersion
+	if err := n.prepare(&r.commonNode); err != nil {
+		return nil, err
+	}
 	r.Type = fields.NodeTypeReply
 	switch concreteParent := parent.(type) {
 	case *Community:
@@ -261,36 +394,332 @@ func (n *Builder) NewReply(parent interface{}, content *fields.QualifiedContent,
 		return nil, fmt.Errorf("parent must be either a community or reply node")
 
 	}
+	content, err := n.encrypt(content)
+	if err != nil {
+		return nil, err
+	}
 	r.Content = *content
 	r.Metadata = *metadata
 	r.Author = *n.User.ID()
-	idDesc, err := fields.NewHashDescriptor(fields.HashTypeSHA512, int(fields.HashDigestLengthSHA512_256))
-	if err != nil {
+	if err := n.finish(r, &r.commonNode); err != nil {
 		return nil, err
 	}
-	r.IDDesc = *idDesc
+	return r, nil
+}
+
+// signedHashable is a node that can be signed and identified by its hash.
+type signedHashable interface {
+	Hashable
+	MarshalSignedData() ([]byte, error)
+}
+
+// finish signs a new node once all of its other fields are populated and
+// then computes its ID.
+func (n *Builder) finish(node signedHashable, common *commonNode) error {
+	idDesc, err := n.idDescriptor()
+	if err != nil {
+		return err
+	}
+	common.IDDesc = *idDesc
+	return signNode(n.Signer, node, common)
+}
 
+// signNode signs a new node once all of its pre-signature fields, including
+// its ID descriptor, are populated and then computes its ID.
+func signNode(signer Signer, node signedHashable, common *commonNode) error {
 	// we've defined all pre-signature fields, it's time to sign the data
-	signedDataBytes, err := r.MarshalSignedData()
+	signedDataBytes, err := node.MarshalSignedData()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	signature, err := n.Sign(signedDataBytes)
+	qs, err := signData(signer, signedDataBytes)
 	if err != nil {
+		return err
+	}
+	common.Signature = *qs
+
+	// determine the node's final hash ID
+	id, err := computeID(node)
+	if err != nil {
+		return err
+	}
+	common.id = fields.Blob(id)
+	return nil
+}
+
+// NewEdit creates an edit node that replaces the content of the given reply.
+// The Builder's user must be the author of the reply.
+func (n *Builder) NewEdit(target *Reply, content *fields.QualifiedContent, metadata *fields.QualifiedContent) (*Edit, error) {
+	if !target.Author.Equals(n.User.ID()) {
+		return nil, fmt.Errorf("Only the author of a reply can edit it")
+	}
+	e := newEdit()
+	if err := n.prepare(&e.commonNode); err != nil {
 		return nil, err
 	}
-	qs, err := fields.NewQualifiedSignature(fields.SignatureTypeOpenPGP, signature)
+	if e.Created == 0 {
+		return nil, fmt.Errorf("Edits must record their creation time, which schema version %d does not", e.SchemaVersion)
+	}
+	e.Type = fields.NodeTypeEdit
+	e.Parent = *fields.NullHash()
+	e.Depth = 0
+	e.Target = *target.ID()
+	content, err := n.encrypt(content)
 	if err != nil {
 		return nil, err
 	}
-	r.commonNode.Signature = *qs
+	e.Content = *content
+	e.Metadata = *metadata
+	e.Author = *n.User.ID()
+	if err := n.finish(e, &e.commonNode); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
 
-	// determine the node's final hash ID
-	id, err := computeID(r)
+// NewRetraction creates a retraction node that withdraws the given node. The
+// Builder's user must be the author of the target.
+func (n *Builder) NewRetraction(target Node, metadata *fields.QualifiedContent) (*Retraction, error) {
+	if author := authorOf(target); author == nil || !author.Equals(n.User.ID()) {
+		return nil, fmt.Errorf("Only the author of a node can retract it")
+	}
+	r := newRetraction()
+	if err := n.prepare(&r.commonNode); err != nil {
+		return nil, err
+	}
+	r.Type = fields.NodeTypeRetraction
+	r.Parent = *fields.NullHash()
+	r.Depth = 0
+	r.Target = *target.ID()
+	r.Metadata = *metadata
+	r.Author = *n.User.ID()
+	if err := n.finish(r, &r.commonNode); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewReaction creates a reaction node that responds to the given reply with
+// short UTF-8 content, like an emoji.
+func (n *Builder) NewReaction(target *Reply, content *fields.QualifiedContent, metadata *fields.QualifiedContent) (*Reaction, error) {
+	r := newReaction()
+	if err := n.prepare(&r.commonNode); err != nil {
+		return nil, err
+	}
+	r.Type = fields.NodeTypeReaction
+	r.Parent = *fields.NullHash()
+	r.Depth = 0
+	r.Target = *target.ID()
+	r.Content = *content
+	r.Metadata = *metadata
+	r.Author = *n.User.ID()
+	if err := r.validateContent(); err != nil {
+		return nil, err
+	}
+	if err := n.finish(r, &r.commonNode); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewKeyRotation creates a key rotation node that replaces the key introduced
+// by previous with the public key of next. previous must be the Builder's
+// user or one of its KeyRotations, normally the most recent, and the
+// Builder's Signer must hold the key that is being replaced. Nodes created
+// after the rotation must be signed by next.
+func (n *Builder) NewKeyRotation(previous Node, next Signer, metadata *fields.QualifiedContent) (*KeyRotation, error) {
+	if !isKeyRecordOf(previous, n.User) {
+		return nil, fmt.Errorf("Only the user or one of its key rotations can be rotated")
+	}
+	k := newKeyRotation()
+	if err := n.prepare(&k.commonNode); err != nil {
+		return nil, err
+	}
+	if k.Created == 0 {
+		return nil, fmt.Errorf("Key rotations must record their creation time, which schema version %d does not", k.SchemaVersion)
+	}
+	pubkey, err := next.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	keyType, _ := signerTypes(next)
+	qKey, err := fields.NewQualifiedKey(keyType, pubkey)
 	if err != nil {
 		return nil, err
 	}
-	r.id = fields.Blob(id)
+	k.Type = fields.NodeTypeKeyRotation
+	k.Parent = *fields.NullHash()
+	k.Depth = 0
+	k.Previous = *previous.ID()
+	k.PublicKey = *qKey
+	k.Metadata = *metadata
+	k.Author = *n.User.ID()
+	if err := n.finish(k, &k.commonNode); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// NewKeyRevocation creates a key revocation node that revokes the key
+// introduced by revoked, which must be the Builder's user or one of its
+// KeyRotations. The Builder's Signer must hold the key that is being revoked.
+func (n *Builder) NewKeyRevocation(revoked Node, metadata *fields.QualifiedContent) (*KeyRevocation, error) {
+	if !isKeyRecordOf(revoked, n.User) {
+		return nil, fmt.Errorf("Only the key of the user or one of its key rotations can be revoked")
+	}
+	k := newKeyRevocation()
+	if err := n.prepare(&k.commonNode); err != nil {
+		return nil, err
+	}
+	k.Type = fields.NodeTypeKeyRevocation
+	k.Parent = *fields.NullHash()
+	k.Depth = 0
+	k.Revoked = *revoked.ID()
+	k.Metadata = *metadata
+	k.Author = *n.User.ID()
+	if err := n.finish(k, &k.commonNode); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
 
+// NewRole creates a role node that applies the action to the subject within
+// the community. Only the author of the community can grant and revoke the
+// moderator role, while its author and moderators can ban and unban.
+func (n *Builder) NewRole(community *Community, subject *Identity, action fields.RoleAction, metadata *fields.QualifiedContent) (*Role, error) {
+	if _, valid := fields.ValidRoleActions[action]; !valid {
+		return nil, fmt.Errorf("%d is not a valid role action", action)
+	}
+	r := newRole()
+	if err := n.prepare(&r.commonNode); err != nil {
+		return nil, err
+	}
+	if r.Created == 0 {
+		return nil, fmt.Errorf("Roles must record their creation time, which schema version %d does not", r.SchemaVersion)
+	}
+	r.Type = fields.NodeTypeRole
+	r.Parent = *fields.NullHash()
+	r.Depth = 0
+	r.Community = *community.ID()
+	r.Subject = *subject.ID()
+	r.Action = action
+	r.Metadata = *metadata
+	r.Author = *n.User.ID()
+	if err := n.finish(r, &r.commonNode); err != nil {
+		return nil, err
+	}
 	return r, nil
 }
+
+// NewProfileUpdate creates a profile update node that replaces the name and
+// metadata of the given Identity or Community. The Builder's user must be the
+// Identity itself or the author of the Community.
+func (n *Builder) NewProfileUpdate(target Node, name, targetMetadata, metadata *fields.QualifiedContent) (*ProfileUpdate, error) {
+	owner, err := profileOwner(target)
+	if err != nil {
+		return nil, err
+	}
+	if !owner.Equals(n.User.ID()) {
+		return nil, fmt.Errorf("Only the owner of a node can update its profile")
+	}
+	p := newProfileUpdate()
+	if err := n.prepare(&p.commonNode); err != nil {
+		return nil, err
+	}
+	if p.Created == 0 {
+		return nil, fmt.Errorf("Profile updates must record their creation time, which schema version %d does not", p.SchemaVersion)
+	}
+	p.Type = fields.NodeTypeProfileUpdate
+	p.Parent = *fields.NullHash()
+	p.Depth = 0
+	p.Target = *target.ID()
+	p.Name = *name
+	p.TargetMetadata = *targetMetadata
+	p.Metadata = *metadata
+	p.Author = *n.User.ID()
+	if err := n.finish(p, &p.commonNode); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// NewAttachment reads all of the data from r and creates an attachment node
+// with the given MIME type, like "image/png", along with the chunk nodes that
+// hold the data. The data is split into chunks of MaxChunkSize bytes, which
+// are built from the last to the first so that each can refer to the next. As
+// a result, no chunk can be built until all of the data has been read, so
+// NewAttachment holds all of the data in memory at once. Callers should limit
+// the size of the data that they attach. The chunks are returned in the order
+// that they must be added to a ValidatingStore, with the last chunk first, and
+// the attachment must be added after all of them.
+func (n *Builder) NewAttachment(r io.Reader, mimeType string, metadata *fields.QualifiedContent) (*Attachment, []*Chunk, error) {
+	qMIMEType, err := fields.NewQualifiedContent(fields.ContentTypeUTF8String, []byte(mimeType))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := validateMIMEType(qMIMEType); err != nil {
+		return nil, nil, err
+	}
+	var data [][]byte
+	var size int
+	for {
+		buf := make([]byte, MaxChunkSize)
+		read, err := io.ReadFull(r, buf)
+		if read > 0 {
+			data = append(data, buf[:read])
+			size += read
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("Attachment must not be empty")
+	}
+	chunkMetadata, err := fields.NewQualifiedContent(fields.ContentTypeJSON, []byte("{}"))
+	if err != nil {
+		return nil, nil, err
+	}
+	chunks := make([]*Chunk, 0, len(data))
+	next := fields.NullHash()
+	for i := len(data) - 1; i >= 0; i-- {
+		c := newChunk()
+		if err := n.prepare(&c.commonNode); err != nil {
+			return nil, nil, err
+		}
+		qData, err := fields.NewQualifiedContent(fields.ContentTypeBinary, data[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		c.Type = fields.NodeTypeChunk
+		c.Parent = *fields.NullHash()
+		c.Depth = 0
+		c.Next = *next
+		c.Data = *qData
+		c.Metadata = *chunkMetadata
+		c.Author = *n.User.ID()
+		if err := n.finish(c, &c.commonNode); err != nil {
+			return nil, nil, err
+		}
+		chunks = append(chunks, c)
+		next = c.ID()
+	}
+	a := newAttachment()
+	if err := n.prepare(&a.commonNode); err != nil {
+		return nil, nil, err
+	}
+	a.Type = fields.NodeTypeAttachment
+	a.Parent = *fields.NullHash()
+	a.Depth = 0
+	a.MIMEType = *qMIMEType
+	a.Head = *next
+	a.Size = fields.DataSize(size)
+	a.Metadata = *metadata
+	a.Author = *n.User.ID()
+	if err := n.finish(a, &a.commonNode); err != nil {
+		return nil, nil, err
+	}
+	return a, chunks, nil
+}
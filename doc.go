@@ -14,6 +14,16 @@ node. Identity nodes sign themselves. To create a new identity, first create or
     identity, err := forest.NewIdentity(privkey, name, metadata)
     // handle error
 
+Identities can also be defined by an Ed25519 key, which is much smaller than
+an OpenPGP key and faster to sign and verify with:
+
+    _, edkey, err := ed25519.GenerateKey(rand.Reader)
+    // handle error
+    signer, err := forest.NewEd25519Signer(edkey)
+    // handle error
+    identity, err := forest.NewIdentity(signer, name, metadata)
+    // handle error
+
 Identities (and their private keys) can be used to create other nodes with
 the Builder type. You can create community nodes using a builder like so:
 
@@ -0,0 +1,229 @@
+package forest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// Edit nodes replace the content of an existing Reply. An Edit must be signed
+// by the author of the Reply that it targets. Edits are not part of the tree
+// of replies, so they have no parent and a depth of zero. Edits are ordered by
+// their creation time, so they must use a schema version that records it. Use
+// LatestRevision to find the current content of a Reply.
+type Edit struct {
+	commonNode
+	Target  fields.QualifiedHash
+	Content fields.QualifiedContent
+}
+
+func newEdit() *Edit {
+	e := new(Edit)
+	// define how to serialize this node type's fields
+	return e
+}
+
+// TargetID returns the ID of the Reply that is edited by this node.
+func (e *Edit) TargetID() *fields.QualifiedHash {
+	return &e.Target
+}
+
+func (e *Edit) nodeSpecificSerializationOrder() []fields.BidirectionalBinaryMarshaler {
+	return []fields.BidirectionalBinaryMarshaler{&e.Target, &e.Content}
+}
+
+func (e *Edit) SerializationOrder() []fields.BidirectionalBinaryMarshaler {
+	order := e.commonNode.presignSerializationOrder()
+	order = append(order, e.nodeSpecificSerializationOrder()...)
+	order = append(order, e.commonNode.postsignSerializationOrder()...)
+	return order
+}
+
+func (e Edit) MarshalSignedData() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(e.presignSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(e.nodeSpecificSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (e Edit) MarshalBinary() ([]byte, error) {
+	signed, err := e.MarshalSignedData()
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(signed)
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(e.postsignSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func UnmarshalEdit(b []byte) (*Edit, error) {
+	e := newEdit()
+	if err := e.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *Edit) UnmarshalBinary(b []byte) error {
+	if err := e.unmarshalBinaryVersion(b); err != nil {
+		return err
+	}
+	if err := UnmarshalBinary(e, b); err != nil {
+		return err
+	}
+	idBytes, err := computeID(e)
+	if err != nil {
+		return err
+	}
+	e.id = fields.Blob(idBytes)
+	return nil
+}
+
+func (e *Edit) Equals(other interface{}) bool {
+	e2, valid := other.(*Edit)
+	if !valid {
+		return false
+	}
+	return e.commonNode.Equals(&e2.commonNode) &&
+		e.Target.Equals(&e2.Target) &&
+		e.Content.Equals(&e2.Content)
+}
+
+// ValidateShallow checks all fields for internal validity. It does not check
+// the existence or validity of nodes referenced from this node.
+func (e *Edit) ValidateShallow() error {
+	if err := e.commonNode.ValidateShallow(); err != nil {
+		return err
+	}
+	needsValidation := []Validator{&e.Target, &e.Content}
+	for _, nv := range needsValidation {
+		if err := nv.Validate(); err != nil {
+			return err
+		}
+	}
+	if !e.Parent.Equals(fields.NullHash()) {
+		return fmt.Errorf("Edit parent must be null hash, got %v", e.Parent)
+	}
+	if e.Depth != 0 {
+		return fmt.Errorf("Edit depth must be 0, got %d", e.Depth)
+	}
+	if e.Target.Equals(fields.NullHash()) {
+		return fmt.Errorf("Edit target must not be null hash")
+	}
+	if s, err := schemaFor(e.SchemaVersion); err != nil || !s.timestamps {
+		return fmt.Errorf("Edits must record their creation time, which schema version %d does not", e.SchemaVersion)
+	}
+	if e.Author.Equals(fields.NullHash()) {
+		return fmt.Errorf("Edit author must not be null hash")
+	}
+	return nil
+}
+
+// ValidateDeep checks that the edited Reply exists within the store, was
+// written by the author of the Edit, and was not created after the Edit.
+func (e *Edit) ValidateDeep(store Store) error {
+	if _, has, err := store.Get(&e.Author); !has {
+		return fmt.Errorf("Missing required node %v", e.Author)
+	} else if err != nil {
+		return err
+	}
+	targetNode, has, err := store.Get(&e.Target)
+	if !has {
+		return fmt.Errorf("Missing required node %v", e.Target)
+	} else if err != nil {
+		return err
+	}
+	target, ok := targetNode.(*Reply)
+	if !ok {
+		return fmt.Errorf("Edit target must be a reply, got %T", targetNode)
+	}
+	if !target.Author.Equals(&e.Author) {
+		return fmt.Errorf("Edit author %v did not write the target reply", e.Author)
+	}
+	return e.validateCreatedAfter(target)
+}
+
+type editJSON struct {
+	nodeJSON
+	Target  *fields.QualifiedHash    `json:"target"`
+	Content *fields.QualifiedContent `json:"content"`
+}
+
+// MarshalJSON converts the Edit into its canonical JSON form.
+func (e *Edit) MarshalJSON() ([]byte, error) {
+	return json.Marshal(editJSON{
+		nodeJSON: e.commonNode.toJSON(),
+		Target:   &e.Target,
+		Content:  &e.Content,
+	})
+}
+
+// UnmarshalJSON populates the Edit from its canonical JSON form. It fails
+// unless the ID within the JSON matches the Edit's contents. Its signature
+// cannot be checked without its author, so use UnmarshalJSONNode to decode
+// untrusted nodes.
+func (e *Edit) UnmarshalJSON(b []byte) error {
+	var in editJSON
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	if err := requireJSONFields([]jsonField{
+		{"target", in.Target != nil},
+		{"content", in.Content != nil},
+	}); err != nil {
+		return err
+	}
+	if err := e.commonNode.fromJSON(&in.nodeJSON, fields.NodeTypeEdit); err != nil {
+		return err
+	}
+	e.Target = *in.Target
+	e.Content = *in.Content
+	return finishJSON(e, &e.commonNode, in.ID)
+}
+
+// isNewer returns whether a was created after b, breaking ties between
+// nodes with the same creation time by the text form of their IDs.
+func isNewer(a, b *commonNode) bool {
+	if a.Created != b.Created {
+		return a.Created > b.Created
+	}
+	aID, _ := a.ID().MarshalString()
+	bID, _ := b.ID().MarshalString()
+	return aID > bID
+}
+
+// LatestRevision returns the current content of the reply. That is the content
+// of the newest Edit of the reply written by its author, or the reply's own
+// content if it has not been edited. Edits are ordered by their creation time
+// and then by the text form of their IDs. The Edit that supplied the content
+// is also returned, or nil if the reply has not been edited. Signatures are
+// not checked, so the store should only contain validated nodes (see
+// ValidatingStore).
+func LatestRevision(store QueryStore, reply *Reply) (*fields.QualifiedContent, *Edit, error) {
+	page, err := store.Query(&Query{Target: reply.ID()})
+	if err != nil {
+		return nil, nil, err
+	}
+	var latest *Edit
+	for _, node := range page.Nodes {
+		edit, ok := node.(*Edit)
+		if !ok || !edit.Author.Equals(&reply.Author) {
+			continue
+		}
+		if latest == nil || isNewer(&edit.commonNode, &latest.commonNode) {
+			latest = edit
+		}
+	}
+	if latest == nil {
+		return &reply.Content, nil, nil
+	}
+	return &latest.Content, latest, nil
+}
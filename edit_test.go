@@ -0,0 +1,119 @@
+package forest_test
+
+import (
+	"testing"
+	"time"
+
+	forest "git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+func newEditOrSkip(t *testing.T, builder *forest.Builder, target *forest.Reply, text string) *forest.Edit {
+	content := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte(text))
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	edit, err := builder.NewEdit(target, content, metadata)
+	if err != nil {
+		t.Skip("Failed to create edit", err)
+	}
+	return edit
+}
+
+func TestEditRoundTrip(t *testing.T) {
+	identity, signer, community, reply := MakeValidReplyOrSkip(t)
+	edit := newEditOrSkip(t, forest.As(identity, signer), reply, "fixed content")
+	s := forest.NewValidatingStore(forest.NewMemoryStore())
+	for _, node := range []forest.Node{identity, community, reply, edit} {
+		if err := s.Add(node); err != nil {
+			t.Errorf("Store should accept valid node, got %v", err)
+		}
+	}
+	assertRoundTrips(t, s, edit)
+}
+
+func TestEditRequiresReplyAuthor(t *testing.T) {
+	identity, _, community, reply := MakeValidReplyOrSkip(t)
+	other, otherSigner := MakeValidIdentityOrSkip(t)
+	content := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte("vandalism"))
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	if _, err := forest.As(other, otherSigner).NewEdit(reply, content, metadata); err == nil {
+		t.Errorf("Builder should refuse to edit another user's reply")
+	}
+	s := forest.NewValidatingStore(forest.NewMemoryStore())
+	for _, node := range []forest.Node{identity, other, community, reply} {
+		if err := s.Add(node); err != nil {
+			t.Skip("Failed to add node", err)
+		}
+	}
+	// claim that the other user wrote the reply without changing its ID
+	disguised := *reply
+	disguised.Author = *other.ID()
+	forged := newEditOrSkip(t, forest.As(other, otherSigner), &disguised, "vandalism")
+	expectValidationFailure(t, s.Add(forged), forest.CheckReferences)
+}
+
+func TestLatestRevision(t *testing.T) {
+	identity, signer, community, reply := MakeValidReplyOrSkip(t)
+	other, otherSigner := MakeValidIdentityOrSkip(t)
+	store := forest.NewMemoryStore()
+	for _, node := range []forest.Node{identity, other, community, reply} {
+		if err := store.Add(node); err != nil {
+			t.Skip("Failed to add node", err)
+		}
+	}
+	content, latest, err := forest.LatestRevision(store, reply)
+	if err != nil || latest != nil || !content.Equals(&reply.Content) {
+		t.Errorf("Unedited reply should have its original content, got %q (err: %v)", content.Blob, err)
+	}
+	builder := forest.As(identity, signer)
+	now := time.Now()
+	for i, text := range []string{"second", "third", "first"} {
+		when := now.Add(time.Duration([]int{2, 3, 1}[i]) * time.Second)
+		builder.Now = func() time.Time { return when }
+		if err := store.Add(newEditOrSkip(t, builder, reply, text)); err != nil {
+			t.Skip("Failed to add edit", err)
+		}
+	}
+	// edits by other users are ignored, even if they are newer
+	disguised := *reply
+	disguised.Author = *other.ID()
+	otherBuilder := forest.As(other, otherSigner)
+	otherBuilder.Now = func() time.Time { return now.Add(time.Minute) }
+	if err := store.Add(newEditOrSkip(t, otherBuilder, &disguised, "vandalism")); err != nil {
+		t.Skip("Failed to add edit", err)
+	}
+	content, latest, err = forest.LatestRevision(store, reply)
+	if err != nil {
+		t.Fatalf("Failed to find latest revision: %v", err)
+	}
+	if string(content.Blob) != "third" || latest == nil || !latest.Content.Equals(content) {
+		t.Errorf("Expected latest revision to be %q, got %q", "third", content.Blob)
+	}
+}
+
+func TestEditRequiresTimestamps(t *testing.T) {
+	identity, signer, _, reply := MakeValidReplyOrSkip(t)
+	builder := forest.As(identity, signer)
+	builder.SchemaVersion = fields.VersionInitial
+	content := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte("fixed content"))
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	if _, err := builder.NewEdit(reply, content, metadata); err == nil {
+		t.Errorf("Builder should refuse to write a version %d edit", fields.VersionInitial)
+	}
+	unversioned := *newEditOrSkip(t, forest.As(identity, signer), reply, "fixed content")
+	unversioned.SchemaVersion = fields.VersionInitial
+	if err := unversioned.ValidateShallow(); err == nil {
+		t.Errorf("Version %d edit should be invalid", fields.VersionInitial)
+	}
+}
+
+func TestEditCannotPredateReply(t *testing.T) {
+	identity, signer, community, reply := MakeValidReplyOrSkip(t)
+	s := forest.NewValidatingStore(forest.NewMemoryStore())
+	for _, node := range []forest.Node{identity, community, reply} {
+		if err := s.Add(node); err != nil {
+			t.Skip("Failed to add node", err)
+		}
+	}
+	stale := newEditOrSkip(t, builderAt(identity, signer, reply.Created.Time().Add(-time.Hour)), reply, "stale")
+	expectValidationFailure(t, s.Add(stale), forest.CheckReferences)
+}
@@ -0,0 +1,369 @@
+package forest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+
+	"filippo.io/edwards25519"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	// openpgp.Encrypt falls back to RIPEMD160 for recipients whose keys do not
+	// state their preferred hash functions, like those made by openpgp.NewEntity
+	_ "golang.org/x/crypto/ripemd160"
+
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// Encrypted content has the type fields.ContentTypeEncrypted and the
+// following binary form:
+//
+// - the envelope version, one byte
+// - the number of recipients, one byte
+// - for each recipient, the ID of its Identity and the ID of the key record
+// (the Identity or KeyRotation) whose key was used, both as
+// fields.QualifiedHashes, followed by the content key wrapped for that key as
+// a two-byte big-endian length and that many bytes
+// - a random 24-byte nonce
+// - the binary form of the original fields.QualifiedContent, sealed with
+// NaCl secretbox using the content key and nonce
+//
+// The IDs of the recipients are not encrypted.
+const encryptionEnvelopeVersion = 1
+
+const (
+	contentKeySize  = 32
+	secretNonceSize = 24
+	// maxRecipients is the number of recipients that fit within an envelope
+	maxRecipients = 255
+	// maxWrappedKeySize is the largest wrapped key that fits within an
+	// envelope
+	maxWrappedKeySize = 1<<16 - 1
+)
+
+// KeyWrapper encrypts a content key so that it can only be recovered by the
+// holder of the private counterpart of the public key.
+type KeyWrapper func(key *fields.QualifiedKey, contentKey []byte) ([]byte, error)
+
+// keyWrappers holds the KeyWrapper for each type of key that content can be
+// encrypted to. It is not synchronized, so it may only be modified during
+// program initialization.
+var keyWrappers = map[fields.KeyType]KeyWrapper{
+	fields.KeyTypeOpenPGP: wrapKeyOpenPGP,
+	fields.KeyTypeEd25519: wrapKeyEd25519,
+}
+
+// RegisterKeyWrapper allows content to be encrypted to public keys of the
+// given type. The recipients decrypt with a Decrypter for their private key.
+// RegisterKeyWrapper should only be called during program initialization, as
+// the table of key wrappers is not synchronized.
+func RegisterKeyWrapper(keyType fields.KeyType, wrapper KeyWrapper) error {
+	if wrapper == nil {
+		return fmt.Errorf("Key type %d must have a key wrapper", keyType)
+	}
+	if _, exists := keyWrappers[keyType]; exists {
+		return fmt.Errorf("Key type %d already has a key wrapper", keyType)
+	}
+	keyWrappers[keyType] = wrapper
+	return nil
+}
+
+// Decrypter is a Signer that can also decrypt content that was encrypted to
+// its public key. NativeSigner, GPGSigner and Ed25519Signer are Decrypters.
+type Decrypter interface {
+	Signer
+	// UnwrapKey recovers a content key that was wrapped for the Decrypter's
+	// public key by the KeyWrapper for its key type.
+	UnwrapKey(wrapped []byte) ([]byte, error)
+}
+
+// EncryptContent encrypts the content so that it can only be decrypted by the
+// given recipients, using the current key of each recipient's KeyHistory (see
+// LoadKeyHistory). It returns an error if any recipient's current key has been
+// revoked. The author of the content must be among the recipients in order to
+// decrypt it later.
+func EncryptContent(content *fields.QualifiedContent, recipients []*KeyHistory) (*fields.QualifiedContent, error) {
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("Encrypted content must have at least one recipient")
+	} else if len(recipients) > maxRecipients {
+		return nil, fmt.Errorf("Encrypted content can have at most %d recipients, got %d", maxRecipients, len(recipients))
+	}
+	plaintext, err := content.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	var contentKey [contentKeySize]byte
+	if _, err := io.ReadFull(rand.Reader, contentKey[:]); err != nil {
+		return nil, err
+	}
+	var nonce [secretNonceSize]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+	envelope := new(bytes.Buffer)
+	envelope.Write([]byte{encryptionEnvelopeVersion, byte(len(recipients))})
+	for _, recipient := range recipients {
+		current, err := recipient.Current()
+		if err != nil {
+			return nil, err
+		}
+		keyType := current.Key.Descriptor.Type
+		wrap, ok := keyWrappers[keyType]
+		if !ok {
+			return nil, fmt.Errorf("Cannot encrypt to keys of type %d", keyType)
+		}
+		wrapped, err := wrap(current.Key, contentKey[:])
+		if err != nil {
+			return nil, err
+		}
+		if len(wrapped) > maxWrappedKeySize {
+			return nil, fmt.Errorf("Wrapped key can be at most %d bytes, got %d", maxWrappedKeySize, len(wrapped))
+		}
+		if err := fields.MarshalAllInto(envelope, recipient.Identity.ID(), current.Record); err != nil {
+			return nil, err
+		}
+		var size [2]byte
+		binary.BigEndian.PutUint16(size[:], uint16(len(wrapped)))
+		envelope.Write(size[:])
+		envelope.Write(wrapped)
+	}
+	envelope.Write(nonce[:])
+	envelope.Write(secretbox.Seal(nil, plaintext, &nonce, &contentKey))
+	return fields.NewQualifiedContent(fields.ContentTypeEncrypted, envelope.Bytes())
+}
+
+// EncryptedRecipient describes one of the recipients of encrypted content.
+type EncryptedRecipient struct {
+	// Identity is the ID of the recipient's Identity
+	Identity *fields.QualifiedHash
+	// Record is the ID of the key record (the Identity or one of its
+	// KeyRotations) whose key the content key was wrapped for
+	Record *fields.QualifiedHash
+	// WrappedKey is the content key, wrapped by the KeyWrapper for the type
+	// of the key of Record
+	WrappedKey []byte
+}
+
+// encryptedEnvelope is the parsed binary form of encrypted content.
+type encryptedEnvelope struct {
+	recipients []EncryptedRecipient
+	nonce      [secretNonceSize]byte
+	sealed     []byte
+}
+
+// parseEncryptedContent parses the envelope of encrypted content.
+func parseEncryptedContent(content *fields.QualifiedContent) (*encryptedEnvelope, error) {
+	if content.Descriptor.Type != fields.ContentTypeEncrypted {
+		return nil, fmt.Errorf("Expected encrypted content, got content type %d", content.Descriptor.Type)
+	}
+	b := []byte(content.Blob)
+	if len(b) < 2 {
+		return nil, fmt.Errorf("Encrypted content is too short")
+	}
+	if b[0] != encryptionEnvelopeVersion {
+		return nil, fmt.Errorf("Unsupported encrypted content version %d", b[0])
+	}
+	count := int(b[1])
+	b = b[2:]
+	envelope := &encryptedEnvelope{recipients: make([]EncryptedRecipient, count)}
+	for i := range envelope.recipients {
+		recipient := &envelope.recipients[i]
+		recipient.Identity, recipient.Record = new(fields.QualifiedHash), new(fields.QualifiedHash)
+		rest, err := fields.UnmarshalAll(b, recipient.Identity, recipient.Record)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) < 2 {
+			return nil, fmt.Errorf("Encrypted content is too short")
+		}
+		size := int(binary.BigEndian.Uint16(rest))
+		rest = rest[2:]
+		if len(rest) < size {
+			return nil, fmt.Errorf("Encrypted content is too short")
+		}
+		recipient.WrappedKey = rest[:size]
+		b = rest[size:]
+	}
+	if len(b) < secretNonceSize {
+		return nil, fmt.Errorf("Encrypted content is too short")
+	}
+	copy(envelope.nonce[:], b)
+	envelope.sealed = b[secretNonceSize:]
+	return envelope, nil
+}
+
+// EncryptedRecipients returns the recipients of encrypted content, which are
+// not themselves encrypted.
+func EncryptedRecipients(content *fields.QualifiedContent) ([]EncryptedRecipient, error) {
+	envelope, err := parseEncryptedContent(content)
+	if err != nil {
+		return nil, err
+	}
+	return envelope.recipients, nil
+}
+
+// DecryptContent recovers the original content from encrypted content using
+// the private key of one of its recipients. The Decrypter must hold the
+// private key for the key record that the content was encrypted to for the
+// recipient Identity (see EncryptedRecipients).
+func DecryptContent(content *fields.QualifiedContent, recipient *Identity, decrypter Decrypter) (*fields.QualifiedContent, error) {
+	envelope, err := parseEncryptedContent(content)
+	if err != nil {
+		return nil, err
+	}
+	var wrapped *EncryptedRecipient
+	for i := range envelope.recipients {
+		if envelope.recipients[i].Identity.Equals(recipient.ID()) {
+			wrapped = &envelope.recipients[i]
+			break
+		}
+	}
+	if wrapped == nil {
+		return nil, fmt.Errorf("Content was not encrypted to %v", recipient.ID())
+	}
+	unwrapped, err := decrypter.UnwrapKey(wrapped.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(unwrapped) != contentKeySize {
+		return nil, fmt.Errorf("Content key must be %d bytes, got %d", contentKeySize, len(unwrapped))
+	}
+	var contentKey [contentKeySize]byte
+	copy(contentKey[:], unwrapped)
+	plaintext, ok := secretbox.Open(nil, envelope.sealed, &envelope.nonce, &contentKey)
+	if !ok {
+		return nil, fmt.Errorf("Failed to decrypt content")
+	}
+	decrypted := new(fields.QualifiedContent)
+	if rest, err := fields.UnmarshalAll(plaintext, decrypted); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, fmt.Errorf("Decrypted content has %d bytes of trailing data", len(rest))
+	}
+	return decrypted, decrypted.Validate()
+}
+
+// wrapKeyOpenPGP encrypts the content key to a binary OpenPGP public key.
+func wrapKeyOpenPGP(key *fields.QualifiedKey, contentKey []byte) ([]byte, error) {
+	entity, err := openpgp.ReadEntity(packet.NewReader(bytes.NewBuffer([]byte(key.Blob))))
+	if err != nil {
+		return nil, err
+	}
+	wrapped := new(bytes.Buffer)
+	w, err := openpgp.Encrypt(wrapped, []*openpgp.Entity{entity}, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(contentKey); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return wrapped.Bytes(), nil
+}
+
+// UnwrapKey decrypts a content key that was encrypted to this signer's
+// OpenPGP key.
+func (s NativeSigner) UnwrapKey(wrapped []byte) ([]byte, error) {
+	keyring := openpgp.EntityList([]*openpgp.Entity{(*openpgp.Entity)(&s)})
+	message, err := openpgp.ReadMessage(bytes.NewBuffer(wrapped), keyring, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(message.UnverifiedBody)
+}
+
+// UnwrapKey invokes gpg2 to decrypt a content key that was encrypted to this
+// Signer's configured PGP user. It returns the content key or an error (if
+// any).
+func (s *GPGSigner) UnwrapKey(wrapped []byte) ([]byte, error) {
+	gpg2 := exec.Command("gpg2", "--local-user", s.GPGUserName, "--decrypt")
+	if err := s.Rewriter(gpg2); err != nil {
+		return nil, fmt.Errorf("Error invoking Rewrite: %v", err)
+	}
+	gpg2.Stdin = bytes.NewReader(wrapped)
+	contentKey, err := gpg2.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Error running gpg: %v", err)
+	}
+	return contentKey, nil
+}
+
+// Ed25519 keys are converted to their X25519 equivalents so that content
+// keys can be wrapped with NaCl box. A wrapped key is the sender's ephemeral
+// X25519 public key, a random 24-byte nonce, and the sealed content key.
+const (
+	x25519KeySize = 32
+	boxNonceSize  = 24
+)
+
+// wrapKeyEd25519 encrypts the content key to a raw Ed25519 public key.
+func wrapKeyEd25519(key *fields.QualifiedKey, contentKey []byte) ([]byte, error) {
+	recipient, err := ed25519PublicKeyToX25519(key.Blob)
+	if err != nil {
+		return nil, err
+	}
+	ephemeralPublic, ephemeralPrivate, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [boxNonceSize]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+	wrapped := append(ephemeralPublic[:], nonce[:]...)
+	return box.Seal(wrapped, contentKey, &nonce, recipient, ephemeralPrivate), nil
+}
+
+// UnwrapKey decrypts a content key that was encrypted to this signer's
+// Ed25519 key.
+func (s Ed25519Signer) UnwrapKey(wrapped []byte) ([]byte, error) {
+	if len(wrapped) < x25519KeySize+boxNonceSize {
+		return nil, fmt.Errorf("Wrapped key is too short")
+	}
+	var ephemeralPublic [x25519KeySize]byte
+	var nonce [boxNonceSize]byte
+	copy(ephemeralPublic[:], wrapped)
+	copy(nonce[:], wrapped[x25519KeySize:])
+	contentKey, ok := box.Open(nil, wrapped[x25519KeySize+boxNonceSize:], &nonce, &ephemeralPublic, ed25519PrivateKeyToX25519(ed25519.PrivateKey(s)))
+	if !ok {
+		return nil, fmt.Errorf("Failed to unwrap content key")
+	}
+	return contentKey, nil
+}
+
+// ed25519PublicKeyToX25519 converts an Ed25519 public key to the X25519
+// public key of the same private key.
+func ed25519PublicKeyToX25519(key []byte) (*[x25519KeySize]byte, error) {
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("Ed25519 public key must be %d bytes, got %d", ed25519.PublicKeySize, len(key))
+	}
+	point, err := new(edwards25519.Point).SetBytes(key)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Ed25519 public key: %v", err)
+	}
+	var out [x25519KeySize]byte
+	copy(out[:], point.BytesMontgomery())
+	return &out, nil
+}
+
+// ed25519PrivateKeyToX25519 converts an Ed25519 private key to the X25519
+// private key with the same scalar.
+func ed25519PrivateKeyToX25519(key ed25519.PrivateKey) *[x25519KeySize]byte {
+	digest := sha512.Sum512(key.Seed())
+	var out [x25519KeySize]byte
+	copy(out[:], digest[:x25519KeySize])
+	out[0] &= 248
+	out[31] &= 127
+	out[31] |= 64
+	return &out
+}
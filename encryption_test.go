@@ -0,0 +1,155 @@
+package forest_test
+
+import (
+	"testing"
+	"time"
+
+	forest "git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+func makeEd25519IdentityOrSkip(t *testing.T) (*forest.Identity, forest.Signer) {
+	signer := MakeEd25519SignerOrSkip(t)
+	name := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte("Ed25519 Name"))
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	identity, err := forest.NewIdentity(signer, name, metadata)
+	if err != nil {
+		t.Skip("Failed to create identity", err)
+	}
+	return identity, signer
+}
+
+func decrypterOrFail(t *testing.T, signer forest.Signer) forest.Decrypter {
+	decrypter, ok := signer.(forest.Decrypter)
+	if !ok {
+		t.Fatalf("Signer of type %T should be a Decrypter", signer)
+	}
+	return decrypter
+}
+
+// keyHistoryOrSkip loads the key history of the identity from the store.
+func keyHistoryOrSkip(t *testing.T, store forest.QueryStore, identity *forest.Identity) *forest.KeyHistory {
+	history, err := forest.LoadKeyHistory(store, identity)
+	if err != nil {
+		t.Skip("Failed to load key history", err)
+	}
+	return history
+}
+
+// keyHistoriesOrSkip loads the key histories of identities that have never
+// rotated their keys.
+func keyHistoriesOrSkip(t *testing.T, identities ...*forest.Identity) []*forest.KeyHistory {
+	s := forest.NewMemoryStore()
+	histories := make([]*forest.KeyHistory, len(identities))
+	for i, identity := range identities {
+		if err := s.Add(identity); err != nil {
+			t.Skip("Failed to add identity", err)
+		}
+		histories[i] = keyHistoryOrSkip(t, s, identity)
+	}
+	return histories
+}
+
+func TestEncryptContent(t *testing.T) {
+	pgpIdentity, pgpSigner := MakeValidIdentityOrSkip(t)
+	edIdentity, edSigner := makeEd25519IdentityOrSkip(t)
+	outsider, outsiderSigner := makeEd25519IdentityOrSkip(t)
+	content := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte(`{"secret":true}`))
+	encrypted, err := forest.EncryptContent(content, keyHistoriesOrSkip(t, pgpIdentity, edIdentity))
+	if err != nil {
+		t.Fatalf("Failed to encrypt content: %v", err)
+	}
+	if encrypted.Descriptor.Type != fields.ContentTypeEncrypted {
+		t.Errorf("Expected encrypted content type, got %d", encrypted.Descriptor.Type)
+	}
+	for identity, signer := range map[*forest.Identity]forest.Signer{pgpIdentity: pgpSigner, edIdentity: edSigner} {
+		decrypted, err := forest.DecryptContent(encrypted, identity, decrypterOrFail(t, signer))
+		if err != nil {
+			t.Errorf("Recipient with %T should be able to decrypt content, got %v", signer, err)
+		} else if !decrypted.Equals(content) {
+			t.Errorf("Decrypted content does not match the original")
+		}
+	}
+	if _, err := forest.DecryptContent(encrypted, outsider, decrypterOrFail(t, outsiderSigner)); err == nil {
+		t.Errorf("Identity that is not a recipient should not be able to decrypt content")
+	}
+	// the wrong private key cannot unwrap a recipient's content key
+	if _, err := forest.DecryptContent(encrypted, edIdentity, decrypterOrFail(t, outsiderSigner)); err == nil {
+		t.Errorf("Content should not be decrypted with the wrong private key")
+	}
+	if _, err := forest.EncryptContent(content, nil); err == nil {
+		t.Errorf("Content without recipients should not be encrypted")
+	}
+}
+
+func TestBuilderEncryptsReplies(t *testing.T) {
+	identity, signer := makeEd25519IdentityOrSkip(t)
+	friend, friendSigner := MakeValidIdentityOrSkip(t)
+	name := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte("Test Community"))
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	community, err := forest.As(identity, signer).NewCommunity(name, metadata)
+	if err != nil {
+		t.Skip("Failed to create community", err)
+	}
+	reply := newReplyOrSkip(t, forest.As(identity, signer).EncryptTo(keyHistoriesOrSkip(t, identity, friend)...), community, "private")
+	if reply.Content.Descriptor.Type != fields.ContentTypeEncrypted {
+		t.Fatalf("Expected encrypted reply content, got content type %d", reply.Content.Descriptor.Type)
+	}
+	s := forest.NewValidatingStore(forest.NewMemoryStore())
+	for _, node := range []forest.Node{identity, friend, community, reply} {
+		if err := s.Add(node); err != nil {
+			t.Errorf("Store should accept valid node, got %v", err)
+		}
+	}
+	b, err := reply.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal reply: %v", err)
+	}
+	decoded, err := forest.UnmarshalReply(b)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal reply: %v", err)
+	}
+	for recipient, signer := range map[*forest.Identity]forest.Signer{identity: signer, friend: friendSigner} {
+		content, err := forest.DecryptContent(&decoded.Content, recipient, decrypterOrFail(t, signer))
+		if err != nil {
+			t.Errorf("Recipient should be able to decrypt reply, got %v", err)
+		} else if content.Descriptor.Type != fields.ContentTypeUTF8String || string(content.Blob) != "private" {
+			t.Errorf("Expected decrypted reply to be %q, got %q", "private", content.Blob)
+		}
+	}
+	// the signature covers the ciphertext, so it cannot be replaced
+	tampered := *decoded
+	tampered.Content = *QualifiedContentOrSkip(t, fields.ContentTypeEncrypted, []byte("tampered"))
+	if valid, err := forest.ValidateSignature(&tampered, identity); err == nil && valid {
+		t.Errorf("Signature should not be valid for different ciphertext")
+	}
+}
+
+func TestEncryptContentUsesCurrentKey(t *testing.T) {
+	s, identity, rotation, oldSigner, newSigner := makeRotatedIdentityOrSkip(t)
+	content := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte(`{"secret":true}`))
+	encrypted, err := forest.EncryptContent(content, []*forest.KeyHistory{keyHistoryOrSkip(t, s, identity)})
+	if err != nil {
+		t.Fatalf("Failed to encrypt content: %v", err)
+	}
+	recipients, err := forest.EncryptedRecipients(encrypted)
+	if err != nil {
+		t.Fatalf("Failed to read recipients: %v", err)
+	}
+	if len(recipients) != 1 || !recipients[0].Identity.Equals(identity.ID()) || !recipients[0].Record.Equals(rotation.ID()) {
+		t.Errorf("Content should be encrypted to the key of the latest key rotation")
+	}
+	if _, err := forest.DecryptContent(encrypted, identity, decrypterOrFail(t, newSigner)); err != nil {
+		t.Errorf("Current key should decrypt content, got %v", err)
+	}
+	if _, err := forest.DecryptContent(encrypted, identity, decrypterOrFail(t, oldSigner)); err == nil {
+		t.Errorf("Replaced key should not decrypt content")
+	}
+	revocation := newKeyRevocationOrSkip(t, builderAt(identity, newSigner, rotation.Created.Time().Add(time.Second)), rotation)
+	if err := s.Add(revocation); err != nil {
+		t.Fatalf("Store should accept valid key revocation, got %v", err)
+	}
+	if _, err := forest.EncryptContent(content, []*forest.KeyHistory{keyHistoryOrSkip(t, s, identity)}); err == nil {
+		t.Errorf("Content should not be encrypted to a revoked key")
+	}
+}
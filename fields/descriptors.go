@@ -24,6 +24,19 @@ func marshalTextDescriptor(descriptorType encoding.TextMarshaler, length encodin
 	return buf.Bytes(), nil
 }
 
+// unmarshalTextDescriptor parses the text form of a descriptor as produced by
+// marshalTextDescriptor.
+func unmarshalTextDescriptor(b []byte, descriptorType encoding.TextUnmarshaler, length encoding.TextUnmarshaler) error {
+	parts := bytes.SplitN(b, []byte("_"), 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("Invalid descriptor %q, expected <type>_<length>", b)
+	}
+	if err := descriptorType.UnmarshalText(parts[0]); err != nil {
+		return err
+	}
+	return length.UnmarshalText(parts[1])
+}
+
 // concrete descriptors
 type HashDescriptor struct {
 	Type   HashType
@@ -52,6 +65,14 @@ func (d *HashDescriptor) MarshalText() ([]byte, error) {
 	return marshalTextDescriptor(d.Type, d.Length)
 }
 
+// UnmarshalText parses the text form of the descriptor and ensures that it is valid.
+func (d *HashDescriptor) UnmarshalText(b []byte) error {
+	if err := unmarshalTextDescriptor(b, &d.Type, &d.Length); err != nil {
+		return err
+	}
+	return d.Validate()
+}
+
 func (d *HashDescriptor) Validate() error {
 	validLengths, validType := ValidHashTypes[d.Type]
 	if !validType {
@@ -97,6 +118,14 @@ func (d *ContentDescriptor) MarshalText() ([]byte, error) {
 	return marshalTextDescriptor(d.Type, d.Length)
 }
 
+// UnmarshalText parses the text form of the descriptor and ensures that it is valid.
+func (d *ContentDescriptor) UnmarshalText(b []byte) error {
+	if err := unmarshalTextDescriptor(b, &d.Type, &d.Length); err != nil {
+		return err
+	}
+	return d.Validate()
+}
+
 func (d *ContentDescriptor) Validate() error {
 	_, validType := ValidContentTypes[d.Type]
 	if !validType {
@@ -132,6 +161,14 @@ func (d *SignatureDescriptor) MarshalText() ([]byte, error) {
 	return marshalTextDescriptor(d.Type, d.Length)
 }
 
+// UnmarshalText parses the text form of the descriptor and ensures that it is valid.
+func (d *SignatureDescriptor) UnmarshalText(b []byte) error {
+	if err := unmarshalTextDescriptor(b, &d.Type, &d.Length); err != nil {
+		return err
+	}
+	return d.Validate()
+}
+
 func (d *SignatureDescriptor) Validate() error {
 	_, validType := ValidSignatureTypes[d.Type]
 	if !validType {
@@ -167,6 +204,14 @@ func (d *KeyDescriptor) MarshalText() ([]byte, error) {
 	return marshalTextDescriptor(d.Type, d.Length)
 }
 
+// UnmarshalText parses the text form of the descriptor and ensures that it is valid.
+func (d *KeyDescriptor) UnmarshalText(b []byte) error {
+	if err := unmarshalTextDescriptor(b, &d.Type, &d.Length); err != nil {
+		return err
+	}
+	return d.Validate()
+}
+
 func (d *KeyDescriptor) Validate() error {
 	_, validType := ValidKeyTypes[d.Type]
 	if !validType {
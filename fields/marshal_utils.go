@@ -2,7 +2,9 @@ package fields
 
 import (
 	"encoding"
+	"fmt"
 	"io"
+	"strings"
 )
 
 type BidirectionalBinaryMarshaler interface {
@@ -57,14 +59,66 @@ func MarshalAllInto(w io.Writer, marshalers ...encoding.BinaryMarshaler) error {
 	return nil
 }
 
+// UnmarshalError describes a failure to unmarshal a field from its binary
+// representation. Offset is the position of the start of the field within
+// the input to the outermost call to UnmarshalAll, and Field is the
+// slash-separated path of the types containing the field.
+type UnmarshalError struct {
+	Offset int
+	Field  string
+	Err    error
+}
+
+func (e *UnmarshalError) Error() string {
+	return fmt.Sprintf("Failed to unmarshal %s at byte offset %d: %v", e.Field, e.Offset, e.Err)
+}
+
+// Unwrap returns the reason that unmarshaling failed. It is io.ErrUnexpectedEOF
+// when the input is too short.
+func (e *UnmarshalError) Unwrap() error {
+	return e.Err
+}
+
+// fieldName returns the name of the type of a field for use within an
+// UnmarshalError.
+func fieldName(field interface{}) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", field), "*")
+}
+
+// wrapUnmarshalError describes a failure to unmarshal field starting at offset.
+// If err already describes a failure within one of the field's own fields, its
+// offset and field path are made relative to the containing field.
+func wrapUnmarshalError(field interface{}, offset int, err error) error {
+	if inner, ok := err.(*UnmarshalError); ok {
+		return &UnmarshalError{
+			Offset: offset + inner.Offset,
+			Field:  fieldName(field) + "/" + inner.Field,
+			Err:    inner.Err,
+		}
+	}
+	return &UnmarshalError{Offset: offset, Field: fieldName(field), Err: err}
+}
+
+// errShortInput reports that a field needed more bytes than were available.
+func errShortInput(needed, available int) error {
+	return fmt.Errorf("Need %d bytes, only %d available: %w", needed, available, io.ErrUnexpectedEOF)
+}
+
+// UnmarshalAll unmarshals each of the unmarshalers from consecutive regions of
+// b and returns the bytes that were not consumed. Failures are returned as an
+// *UnmarshalError.
 func UnmarshalAll(b []byte, unmarshalers ...ProgressiveBinaryUnmarshaler) ([]byte, error) {
 	currentBytesConsumed := 0
 	for _, unmarshaler := range unmarshalers {
 		byteSubrange := b[currentBytesConsumed:]
 		if err := unmarshaler.UnmarshalBinary(byteSubrange); err != nil {
-			return nil, err
+			return nil, wrapUnmarshalError(unmarshaler, currentBytesConsumed, err)
+		}
+		consumed := unmarshaler.BytesConsumed()
+		if consumed > len(byteSubrange) {
+			return nil, wrapUnmarshalError(unmarshaler, currentBytesConsumed, errShortInput(consumed, len(byteSubrange)))
 		}
-		currentBytesConsumed += unmarshaler.BytesConsumed()
+		currentBytesConsumed += consumed
 	}
 	return b[currentBytesConsumed:], nil
 }
@@ -2,18 +2,39 @@ package fields
 
 import (
 	"bytes"
+	"crypto/sha512"
 	"encoding/base64"
 	"encoding/binary"
 	"fmt"
+	"hash"
 	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
 )
 
 const (
 	// CurrentVersion is the Forest version that this library writes
-	CurrentVersion Version = 1
+	CurrentVersion Version = VersionTimestamps
+
+	// VersionInitial is the first version of the Forest
+	VersionInitial Version = 1
+
+	// VersionTimestamps is the first version of the Forest in which every node
+	// records the time at which it was created
+	VersionTimestamps Version = 2
 
 	// HashDigestLengthSHA512_256 is the length of the digest produced by the SHA512/256 hash algorithm
 	HashDigestLengthSHA512_256 ContentLength = 32
+
+	// HashDigestLengthSHA3_256 is the length of the digest produced by the SHA3-256 hash algorithm
+	HashDigestLengthSHA3_256 ContentLength = 32
+
+	// HashDigestLengthBLAKE2b_256 is the length of the digest produced by the BLAKE2b-256 hash algorithm
+	HashDigestLengthBLAKE2b_256 ContentLength = 32
 )
 
 // multiByteSerializationOrder defines the order in which multi-byte
@@ -32,6 +53,9 @@ func (g genericType) MarshalBinary() ([]byte, error) {
 }
 
 func (g *genericType) UnmarshalBinary(b []byte) error {
+	if len(b) < sizeofgenericType {
+		return errShortInput(sizeofgenericType, len(b))
+	}
 	buf := bytes.NewBuffer(b)
 	return binary.Read(buf, multiByteSerializationOrder, g)
 }
@@ -48,6 +72,37 @@ func (g *genericType) Equals(g2 *genericType) bool {
 	return *g == *g2
 }
 
+// unmarshalTextNumber parses the text form of a number that is preceded by
+// a single-character prefix, like "B32". It rejects signs, leading zeroes and
+// numbers that do not fit within the given number of bits.
+func unmarshalTextNumber(b []byte, prefix byte, bitSize int) (uint64, error) {
+	if len(b) < 2 || b[0] != prefix {
+		return 0, fmt.Errorf("Expected %q followed by a number, got %q", prefix, b)
+	}
+	digits := b[1:]
+	if len(digits) > 1 && digits[0] == '0' {
+		return 0, fmt.Errorf("Number %q must not have leading zeroes", b)
+	}
+	for _, digit := range digits {
+		if digit < '0' || digit > '9' {
+			return 0, fmt.Errorf("Number %q must contain only decimal digits", b)
+		}
+	}
+	return strconv.ParseUint(string(digits), 10, bitSize)
+}
+
+// validateTypeName ensures that a name can be used within the text form of
+// a descriptor.
+func validateTypeName(name string) error {
+	if name == "" {
+		return fmt.Errorf("Type names must not be empty")
+	}
+	if strings.Contains(name, "_") {
+		return fmt.Errorf("Type name %q must not contain underscores", name)
+	}
+	return nil
+}
+
 // ContentLength represents the length of a piece of data in the Forest
 type ContentLength uint16
 
@@ -78,9 +133,22 @@ func (c ContentLength) MarshalText() ([]byte, error) {
 	return []byte(fmt.Sprintf("B%d", c)), nil
 }
 
+// UnmarshalText parses the text form of a ContentLength, like "B32"
+func (c *ContentLength) UnmarshalText(b []byte) error {
+	n, err := unmarshalTextNumber(b, 'B', 16)
+	if err != nil {
+		return err
+	}
+	*c = ContentLength(n)
+	return nil
+}
+
 // UnmarshalBinary converts from the binary representation of a ContentLength
 // back to its structured form
 func (c *ContentLength) UnmarshalBinary(b []byte) error {
+	if len(b) < sizeofContentLength {
+		return errShortInput(sizeofContentLength, len(b))
+	}
 	buf := bytes.NewBuffer(b)
 	return binary.Read(buf, multiByteSerializationOrder, c)
 }
@@ -109,9 +177,22 @@ func (t TreeDepth) MarshalText() ([]byte, error) {
 	return []byte(fmt.Sprintf("L%d", t)), nil
 }
 
+// UnmarshalText parses the text form of a TreeDepth, like "L2"
+func (t *TreeDepth) UnmarshalText(b []byte) error {
+	n, err := unmarshalTextNumber(b, 'L', 32)
+	if err != nil {
+		return err
+	}
+	*t = TreeDepth(n)
+	return nil
+}
+
 // UnmarshalBinary converts from the binary representation of a TreeDepth
 // back to its structured form
 func (t *TreeDepth) UnmarshalBinary(b []byte) error {
+	if len(b) < sizeofTreeDepth {
+		return errShortInput(sizeofTreeDepth, len(b))
+	}
 	buf := bytes.NewBuffer(b)
 	return binary.Read(buf, multiByteSerializationOrder, t)
 }
@@ -137,6 +218,16 @@ func (v Blob) MarshalText() ([]byte, error) {
 	return []byte(based), nil
 }
 
+// UnmarshalText decodes the unpadded base64url text form of a Blob
+func (v *Blob) UnmarshalText(b []byte) error {
+	decoded, err := base64.RawURLEncoding.Strict().DecodeString(string(b))
+	if err != nil {
+		return err
+	}
+	*v = decoded
+	return nil
+}
+
 // UnmarshalBinary converts from the binary representation of a Blob
 // back to its structured form
 func (v *Blob) UnmarshalBinary(b []byte) error {
@@ -169,9 +260,22 @@ func (v Version) MarshalText() ([]byte, error) {
 	return []byte(fmt.Sprintf("V%d", v)), nil
 }
 
+// UnmarshalText parses the text form of a Version, like "V1"
+func (v *Version) UnmarshalText(b []byte) error {
+	n, err := unmarshalTextNumber(b, 'V', 64)
+	if err != nil {
+		return err
+	}
+	*v = Version(n)
+	return nil
+}
+
 // UnmarshalBinary converts from the binary representation of a Version
 // back to its structured form
 func (v *Version) UnmarshalBinary(b []byte) error {
+	if len(b) < sizeofVersion {
+		return errShortInput(sizeofVersion, len(b))
+	}
 	buf := bytes.NewBuffer(b)
 	return binary.Read(buf, multiByteSerializationOrder, v)
 }
@@ -184,6 +288,107 @@ func (v *Version) Equals(v2 *Version) bool {
 	return *v == *v2
 }
 
+// Timestamp represents a moment in time as the number of milliseconds since
+// the Unix epoch in UTC
+type Timestamp uint64
+
+const sizeofTimestamp = 8
+
+// TimestampFrom converts a time into a Timestamp, discarding any precision
+// finer than a millisecond
+func TimestampFrom(t time.Time) Timestamp {
+	return Timestamp(t.UnixNano() / int64(time.Millisecond))
+}
+
+// Time converts the Timestamp into a time.Time
+func (t Timestamp) Time() time.Time {
+	return time.Unix(0, int64(t)*int64(time.Millisecond)).UTC()
+}
+
+// MarshalBinary converts the Timestamp into its binary representation
+func (t Timestamp) MarshalBinary() ([]byte, error) {
+	b := new(bytes.Buffer)
+	err := binary.Write(b, multiByteSerializationOrder, t)
+	return b.Bytes(), err
+}
+
+func (t Timestamp) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("T%d", t)), nil
+}
+
+// UnmarshalText parses the text form of a Timestamp, like "T1560000000000"
+func (t *Timestamp) UnmarshalText(b []byte) error {
+	n, err := unmarshalTextNumber(b, 'T', 64)
+	if err != nil {
+		return err
+	}
+	*t = Timestamp(n)
+	return nil
+}
+
+// UnmarshalBinary converts from the binary representation of a Timestamp
+// back to its structured form
+func (t *Timestamp) UnmarshalBinary(b []byte) error {
+	if len(b) < sizeofTimestamp {
+		return errShortInput(sizeofTimestamp, len(b))
+	}
+	buf := bytes.NewBuffer(b)
+	return binary.Read(buf, multiByteSerializationOrder, t)
+}
+
+func (t *Timestamp) BytesConsumed() int {
+	return sizeofTimestamp
+}
+
+func (t *Timestamp) Equals(t2 *Timestamp) bool {
+	return *t == *t2
+}
+
+// DataSize represents the number of bytes of a quantity of data, like the
+// data of an attachment
+type DataSize uint64
+
+const sizeofDataSize = 8
+
+// MarshalBinary converts the DataSize into its binary representation
+func (d DataSize) MarshalBinary() ([]byte, error) {
+	b := new(bytes.Buffer)
+	err := binary.Write(b, multiByteSerializationOrder, d)
+	return b.Bytes(), err
+}
+
+func (d DataSize) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("S%d", d)), nil
+}
+
+// UnmarshalText parses the text form of a DataSize, like "S1024"
+func (d *DataSize) UnmarshalText(b []byte) error {
+	n, err := unmarshalTextNumber(b, 'S', 64)
+	if err != nil {
+		return err
+	}
+	*d = DataSize(n)
+	return nil
+}
+
+// UnmarshalBinary converts from the binary representation of a DataSize
+// back to its structured form
+func (d *DataSize) UnmarshalBinary(b []byte) error {
+	if len(b) < sizeofDataSize {
+		return errShortInput(sizeofDataSize, len(b))
+	}
+	buf := bytes.NewBuffer(b)
+	return binary.Read(buf, multiByteSerializationOrder, d)
+}
+
+func (d *DataSize) BytesConsumed() int {
+	return sizeofDataSize
+}
+
+func (d *DataSize) Equals(d2 *DataSize) bool {
+	return *d == *d2
+}
+
 // specialized types
 type NodeType genericType
 
@@ -192,18 +397,66 @@ const (
 	NodeTypeIdentity NodeType = iota
 	NodeTypeCommunity
 	NodeTypeReply
+	NodeTypeEdit
+	NodeTypeRetraction
+	NodeTypeReaction
+	NodeTypeKeyRotation
+	NodeTypeKeyRevocation
+	NodeTypeRole
+	NodeTypeProfileUpdate
+	NodeTypeChunk
+	NodeTypeAttachment
 )
 
 var ValidNodeTypes = map[NodeType]struct{}{
-	NodeTypeIdentity:  struct{}{},
-	NodeTypeCommunity: struct{}{},
-	NodeTypeReply:     struct{}{},
+	NodeTypeIdentity:      struct{}{},
+	NodeTypeCommunity:     struct{}{},
+	NodeTypeReply:         struct{}{},
+	NodeTypeEdit:          struct{}{},
+	NodeTypeRetraction:    struct{}{},
+	NodeTypeReaction:      struct{}{},
+	NodeTypeKeyRotation:   struct{}{},
+	NodeTypeKeyRevocation: struct{}{},
+	NodeTypeRole:          struct{}{},
+	NodeTypeProfileUpdate: struct{}{},
+	NodeTypeChunk:         struct{}{},
+	NodeTypeAttachment:    struct{}{},
 }
 
 var nodeTypeNames = map[NodeType]string{
-	NodeTypeIdentity:  "identity",
-	NodeTypeCommunity: "community",
-	NodeTypeReply:     "reply",
+	NodeTypeIdentity:      "identity",
+	NodeTypeCommunity:     "community",
+	NodeTypeReply:         "reply",
+	NodeTypeEdit:          "edit",
+	NodeTypeRetraction:    "retraction",
+	NodeTypeReaction:      "reaction",
+	NodeTypeKeyRotation:   "key-rotation",
+	NodeTypeKeyRevocation: "key-revocation",
+	NodeTypeRole:          "role",
+	NodeTypeProfileUpdate: "profile-update",
+	NodeTypeChunk:         "chunk",
+	NodeTypeAttachment:    "attachment",
+}
+
+// RegisterNodeType makes a new NodeType valid so that nodes using it can be
+// unmarshaled and validated. The name is used in the text form of the node
+// type and must be unique. It should only be called during program
+// initialization, as the table of valid node types is not synchronized.
+func RegisterNodeType(t NodeType, name string) error {
+	if name == "" {
+		return fmt.Errorf("Type names must not be empty")
+	}
+	if _, exists := ValidNodeTypes[t]; exists {
+		return fmt.Errorf("Node type %d is already registered as %s", t, nodeTypeNames[t])
+	}
+	for existing, existingName := range nodeTypeNames {
+		if existingName == name {
+			return fmt.Errorf("Node type name %s is already used by node type %d", name, existing)
+		}
+	}
+	ValidNodeTypes[t] = struct{}{}
+	nodeTypeNames[t] = name
+	return nil
 }
 
 func (t NodeType) MarshalBinary() ([]byte, error) {
@@ -214,6 +467,17 @@ func (t NodeType) MarshalText() ([]byte, error) {
 	return []byte(nodeTypeNames[t]), nil
 }
 
+// UnmarshalText parses the name of a node type
+func (t *NodeType) UnmarshalText(b []byte) error {
+	for candidate, name := range nodeTypeNames {
+		if name == string(b) {
+			*t = candidate
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown node type %q", b)
+}
+
 func (t *NodeType) UnmarshalBinary(b []byte) error {
 	if err := (*genericType)(t).UnmarshalBinary(b); err != nil {
 		return err
@@ -232,23 +496,158 @@ func (t *NodeType) Equals(t2 *NodeType) bool {
 	return ((*genericType)(t)).Equals((*genericType)(t2))
 }
 
+// RoleAction is the change to the role of an Identity within a Community
+// made by a Role node
+type RoleAction genericType
+
+const (
+	sizeofRoleAction = sizeofgenericType
+	// RoleActionGrantModerator makes the subject a moderator of the community
+	RoleActionGrantModerator RoleAction = iota
+	// RoleActionRevokeModerator removes the subject from the moderators of the community
+	RoleActionRevokeModerator
+	// RoleActionBan prevents the subject from posting within the community
+	RoleActionBan
+	// RoleActionUnban reverses an earlier ban of the subject
+	RoleActionUnban
+)
+
+var ValidRoleActions = map[RoleAction]struct{}{
+	RoleActionGrantModerator:  struct{}{},
+	RoleActionRevokeModerator: struct{}{},
+	RoleActionBan:             struct{}{},
+	RoleActionUnban:           struct{}{},
+}
+
+var roleActionNames = map[RoleAction]string{
+	RoleActionGrantModerator:  "grant-moderator",
+	RoleActionRevokeModerator: "revoke-moderator",
+	RoleActionBan:             "ban",
+	RoleActionUnban:           "unban",
+}
+
+func (a RoleAction) MarshalBinary() ([]byte, error) {
+	return genericType(a).MarshalBinary()
+}
+
+func (a RoleAction) MarshalText() ([]byte, error) {
+	return []byte(roleActionNames[a]), nil
+}
+
+// UnmarshalText parses the name of a role action
+func (a *RoleAction) UnmarshalText(b []byte) error {
+	for candidate, name := range roleActionNames {
+		if name == string(b) {
+			*a = candidate
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown role action %q", b)
+}
+
+func (a *RoleAction) UnmarshalBinary(b []byte) error {
+	if err := (*genericType)(a).UnmarshalBinary(b); err != nil {
+		return err
+	}
+	if _, valid := ValidRoleActions[*a]; !valid {
+		return fmt.Errorf("%d is not a valid role action", *a)
+	}
+	return nil
+}
+
+func (a *RoleAction) BytesConsumed() int {
+	return sizeofRoleAction
+}
+
+func (a *RoleAction) Equals(a2 *RoleAction) bool {
+	return ((*genericType)(a)).Equals((*genericType)(a2))
+}
+
 type HashType genericType
 
 const (
 	sizeofHashType            = sizeofgenericType
 	HashTypeNullHash HashType = iota
 	HashTypeSHA512
+	HashTypeSHA3
+	HashTypeBLAKE2b
 )
 
 // map to valid lengths
 var ValidHashTypes = map[HashType][]ContentLength{
 	HashTypeNullHash: []ContentLength{0},
 	HashTypeSHA512:   []ContentLength{HashDigestLengthSHA512_256},
+	HashTypeSHA3:     []ContentLength{HashDigestLengthSHA3_256},
+	HashTypeBLAKE2b:  []ContentLength{HashDigestLengthBLAKE2b_256},
 }
 
 var hashNames = map[HashType]string{
 	HashTypeNullHash: "NullHash",
 	HashTypeSHA512:   "SHA512",
+	HashTypeSHA3:     "SHA3",
+	HashTypeBLAKE2b:  "BLAKE2b",
+}
+
+// hashFuncs maps from HashType and digest length to the function that creates
+// an instance of that hash algorithm
+var hashFuncs = map[HashType]map[ContentLength]func() hash.Hash{
+	HashTypeSHA512: {
+		HashDigestLengthSHA512_256: sha512.New512_256,
+	},
+	HashTypeSHA3: {
+		HashDigestLengthSHA3_256: sha3.New256,
+	},
+	HashTypeBLAKE2b: {
+		HashDigestLengthBLAKE2b_256: func() hash.Hash {
+			// only fails when given a key that is too long
+			h, _ := blake2b.New256(nil)
+			return h
+		},
+	},
+}
+
+// RegisterHashType makes a hash algorithm with the given digest length
+// available for use in HashDescriptors. A HashType can be registered with
+// several digest lengths, but the name is only recorded the first time. The
+// name is used in the text form of the hash type and must not contain
+// underscores.
+// It should only be called during program initialization, as the tables of
+// valid hash types are not synchronized.
+func RegisterHashType(t HashType, name string, length ContentLength, newHash func() hash.Hash) error {
+	if t == HashTypeNullHash {
+		return fmt.Errorf("Cannot register a hash function for the null hash")
+	}
+	for _, existing := range ValidHashTypes[t] {
+		if existing == length {
+			return fmt.Errorf("Hash type %d is already registered with length %d", t, length)
+		}
+	}
+	if _, exists := hashNames[t]; !exists {
+		if err := validateTypeName(name); err != nil {
+			return err
+		}
+		hashNames[t] = name
+	}
+	ValidHashTypes[t] = append(ValidHashTypes[t], length)
+	if hashFuncs[t] == nil {
+		hashFuncs[t] = make(map[ContentLength]func() hash.Hash)
+	}
+	hashFuncs[t][length] = newHash
+	return nil
+}
+
+// HashFunc returns the function that creates an instance of the hash algorithm
+// with the given type and digest length.
+func HashFunc(t HashType, length ContentLength) (func() hash.Hash, error) {
+	hashCategory, found := hashFuncs[t]
+	if !found {
+		return nil, fmt.Errorf("Unknown HashType %d", t)
+	}
+	hashFunc, found := hashCategory[length]
+	if !found {
+		return nil, fmt.Errorf("Invalid hash length %d for hash type %d", length, t)
+	}
+	return hashFunc, nil
 }
 
 func (t HashType) MarshalBinary() ([]byte, error) {
@@ -259,6 +658,17 @@ func (t HashType) MarshalText() ([]byte, error) {
 	return []byte(hashNames[t]), nil
 }
 
+// UnmarshalText parses the name of a hash type
+func (t *HashType) UnmarshalText(b []byte) error {
+	for candidate, name := range hashNames {
+		if name == string(b) {
+			*t = candidate
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown hash type %q", b)
+}
+
 func (t *HashType) UnmarshalBinary(b []byte) error {
 	if err := (*genericType)(t).UnmarshalBinary(b); err != nil {
 		return err
@@ -283,16 +693,25 @@ const (
 	sizeofContentType                 = sizeofgenericType
 	ContentTypeUTF8String ContentType = 1
 	ContentTypeJSON       ContentType = 2
+	// ContentTypeEncrypted is content that has been encrypted to a set of
+	// recipients. It wraps content of another type.
+	ContentTypeEncrypted ContentType = 3
+	// ContentTypeBinary is arbitrary binary data, like part of a file
+	ContentTypeBinary ContentType = 4
 )
 
 var ValidContentTypes = map[ContentType]struct{}{
 	ContentTypeUTF8String: struct{}{},
 	ContentTypeJSON:       struct{}{},
+	ContentTypeEncrypted:  struct{}{},
+	ContentTypeBinary:     struct{}{},
 }
 
 var contentNames = map[ContentType]string{
 	ContentTypeUTF8String: "UTF-8",
 	ContentTypeJSON:       "JSON",
+	ContentTypeEncrypted:  "encrypted",
+	ContentTypeBinary:     "binary",
 }
 
 func (t ContentType) MarshalBinary() ([]byte, error) {
@@ -303,6 +722,17 @@ func (t ContentType) MarshalText() ([]byte, error) {
 	return []byte(contentNames[t]), nil
 }
 
+// UnmarshalText parses the name of a content type
+func (t *ContentType) UnmarshalText(b []byte) error {
+	for candidate, name := range contentNames {
+		if name == string(b) {
+			*t = candidate
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown content type %q", b)
+}
+
 func (t *ContentType) UnmarshalBinary(b []byte) error {
 	if err := (*genericType)(t).UnmarshalBinary(b); err != nil {
 		return err
@@ -327,16 +757,35 @@ const (
 	sizeofKeyType          = sizeofgenericType
 	KeyTypeNoKey   KeyType = 0
 	KeyTypeOpenPGP KeyType = 1
+	KeyTypeEd25519 KeyType = 2
 )
 
 var ValidKeyTypes = map[KeyType]struct{}{
 	KeyTypeNoKey:   struct{}{},
 	KeyTypeOpenPGP: struct{}{},
+	KeyTypeEd25519: struct{}{},
 }
 
 var keyNames = map[KeyType]string{
 	KeyTypeNoKey:   "None",
 	KeyTypeOpenPGP: "OpenPGP",
+	KeyTypeEd25519: "Ed25519",
+}
+
+// RegisterKeyType makes a new KeyType valid so that fields using it can be
+// unmarshaled and validated. The name is used in the text form of the key type
+// and must not contain underscores. It should only be called during program
+// initialization, as the table of valid key types is not synchronized.
+func RegisterKeyType(t KeyType, name string) error {
+	if err := validateTypeName(name); err != nil {
+		return err
+	}
+	if _, exists := ValidKeyTypes[t]; exists {
+		return fmt.Errorf("Key type %d is already registered as %s", t, keyNames[t])
+	}
+	ValidKeyTypes[t] = struct{}{}
+	keyNames[t] = name
+	return nil
 }
 
 func (t KeyType) MarshalBinary() ([]byte, error) {
@@ -347,6 +796,17 @@ func (t KeyType) MarshalText() ([]byte, error) {
 	return []byte(keyNames[t]), nil
 }
 
+// UnmarshalText parses the name of a key type
+func (t *KeyType) UnmarshalText(b []byte) error {
+	for candidate, name := range keyNames {
+		if name == string(b) {
+			*t = candidate
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown key type %q", b)
+}
+
 func (t *KeyType) UnmarshalBinary(b []byte) error {
 	if err := (*genericType)(t).UnmarshalBinary(b); err != nil {
 		return err
@@ -370,14 +830,34 @@ type SignatureType genericType
 const (
 	sizeofSignatureType                = sizeofgenericType
 	SignatureTypeOpenPGP SignatureType = 1
+	SignatureTypeEd25519 SignatureType = 2
 )
 
 var ValidSignatureTypes = map[SignatureType]struct{}{
 	SignatureTypeOpenPGP: struct{}{},
+	SignatureTypeEd25519: struct{}{},
 }
 
 var signatureNames = map[SignatureType]string{
 	SignatureTypeOpenPGP: "OpenPGP",
+	SignatureTypeEd25519: "Ed25519",
+}
+
+// RegisterSignatureType makes a new SignatureType valid so that fields using
+// it can be unmarshaled and validated. The name is used in the text form of
+// the signature type and must not contain underscores. It should only be called during
+// program initialization, as the table of valid signature types is not
+// synchronized.
+func RegisterSignatureType(t SignatureType, name string) error {
+	if err := validateTypeName(name); err != nil {
+		return err
+	}
+	if _, exists := ValidSignatureTypes[t]; exists {
+		return fmt.Errorf("Signature type %d is already registered as %s", t, signatureNames[t])
+	}
+	ValidSignatureTypes[t] = struct{}{}
+	signatureNames[t] = name
+	return nil
 }
 
 func (t SignatureType) MarshalBinary() ([]byte, error) {
@@ -388,6 +868,17 @@ func (t SignatureType) MarshalText() ([]byte, error) {
 	return []byte(signatureNames[t]), nil
 }
 
+// UnmarshalText parses the name of a signature type
+func (t *SignatureType) UnmarshalText(b []byte) error {
+	for candidate, name := range signatureNames {
+		if name == string(b) {
+			*t = candidate
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown signature type %q", b)
+}
+
 func (t *SignatureType) UnmarshalBinary(b []byte) error {
 	if err := (*genericType)(t).UnmarshalBinary(b); err != nil {
 		return err
@@ -3,7 +3,9 @@ package fields
 import (
 	"bytes"
 	"encoding"
+	"encoding/json"
 	"fmt"
+	"unicode/utf8"
 )
 
 const minSizeofQualified = sizeofDescriptor
@@ -32,6 +34,29 @@ func marshalTextQualified(first, second encoding.TextMarshaler) ([]byte, error)
 	return buf.Bytes(), nil
 }
 
+// unmarshalTextQualified parses the text form of a qualified value as produced
+// by marshalTextQualified. The text form of the first value must not contain
+// two consecutive underscores.
+func unmarshalTextQualified(b []byte, first, second encoding.TextUnmarshaler) error {
+	separator := bytes.Index(b, []byte("__"))
+	if separator < 0 {
+		return fmt.Errorf("Invalid qualified value %q, expected <descriptor>__<value>", b)
+	}
+	if err := first.UnmarshalText(b[:separator]); err != nil {
+		return err
+	}
+	return second.UnmarshalText(b[separator+len("__"):])
+}
+
+// unmarshalBinaryBlob unmarshals the first length bytes of b into blob, which
+// begins offset bytes into the qualified value containing it.
+func unmarshalBinaryBlob(blob *Blob, b []byte, length, offset int) error {
+	if len(b) < length {
+		return wrapUnmarshalError(blob, offset, errShortInput(length, len(b)))
+	}
+	return blob.UnmarshalBinary(b[:length:length])
+}
+
 // NewQualifiedHash returns a valid QualifiedHash from the given data
 func NewQualifiedHash(t HashType, content []byte) (*QualifiedHash, error) {
 	hd, err := NewHashDescriptor(t, len(content))
@@ -56,10 +81,7 @@ func (q *QualifiedHash) UnmarshalBinary(b []byte) error {
 	if err != nil {
 		return err
 	}
-	if err := q.Blob.UnmarshalBinary(unused[:q.Descriptor.Length]); err != nil {
-		return err
-	}
-	return nil
+	return unmarshalBinaryBlob(&q.Blob, unused, int(q.Descriptor.Length), len(b)-len(unused))
 }
 
 func (q *QualifiedHash) MarshalBinary() ([]byte, error) {
@@ -91,6 +113,24 @@ func (q *QualifiedHash) MarshalString() (string, error) {
 	return string(s), e
 }
 
+// UnmarshalText parses the text form of a QualifiedHash and ensures that it is valid.
+func (q *QualifiedHash) UnmarshalText(b []byte) error {
+	if err := unmarshalTextQualified(b, &q.Descriptor, &q.Blob); err != nil {
+		return err
+	}
+	return q.Validate()
+}
+
+// ParseQualifiedHash parses the text form of a QualifiedHash, like those
+// produced by MarshalString.
+func ParseQualifiedHash(s string) (*QualifiedHash, error) {
+	q := new(QualifiedHash)
+	if err := q.UnmarshalText([]byte(s)); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
 func (q *QualifiedHash) Validate() error {
 	if err := q.Descriptor.Validate(); err != nil {
 		return err
@@ -130,10 +170,7 @@ func (q *QualifiedContent) UnmarshalBinary(b []byte) error {
 	if err != nil {
 		return err
 	}
-	if err := q.Blob.UnmarshalBinary(unused[:q.Descriptor.Length]); err != nil {
-		return err
-	}
-	return nil
+	return unmarshalBinaryBlob(&q.Blob, unused, int(q.Descriptor.Length), len(b)-len(unused))
 }
 
 func (q *QualifiedContent) MarshalBinary() ([]byte, error) {
@@ -168,6 +205,56 @@ func (q *QualifiedContent) MarshalText() ([]byte, error) {
 	}
 }
 
+// UnmarshalText parses the text form of a QualifiedContent and ensures that it
+// is valid. Textual content types are written without a separator between the
+// descriptor and the content, so the length within the descriptor is used to
+// determine where the content begins.
+func (q *QualifiedContent) UnmarshalText(b []byte) error {
+	typeEnd := bytes.IndexByte(b, '_')
+	if typeEnd < 0 {
+		return fmt.Errorf("Invalid qualified content %q, expected <type>_<length>", b)
+	}
+	if err := q.Descriptor.Type.UnmarshalText(b[:typeEnd]); err != nil {
+		return err
+	}
+	switch q.Descriptor.Type {
+	case ContentTypeUTF8String:
+		fallthrough
+	case ContentTypeJSON:
+		if err := q.unmarshalTextInline(b[typeEnd+1:]); err != nil {
+			return err
+		}
+	default:
+		if err := unmarshalTextQualified(b, &q.Descriptor, &q.Blob); err != nil {
+			return err
+		}
+	}
+	return q.Validate()
+}
+
+// unmarshalTextInline parses a length immediately followed by the content
+// that it describes, like "B5hello". Because the length is written without
+// leading zeroes, at most one prefix of the digits can match the length of
+// the remaining text.
+func (q *QualifiedContent) unmarshalTextInline(b []byte) error {
+	if len(b) < 2 || b[0] != 'B' {
+		return fmt.Errorf("Invalid content length in %q", b)
+	}
+	digits := b[1:]
+	for end := 1; end <= len(digits); end++ {
+		var length ContentLength
+		if err := length.UnmarshalText(b[:end+1]); err != nil {
+			break
+		}
+		if int(length) == len(digits)-end {
+			q.Descriptor.Length = length
+			q.Blob = Blob(append([]byte{}, digits[end:]...))
+			return nil
+		}
+	}
+	return fmt.Errorf("Content length in %q does not match the length of the content", b)
+}
+
 func (q *QualifiedContent) Validate() error {
 	if err := q.Descriptor.Validate(); err != nil {
 		return err
@@ -178,6 +265,77 @@ func (q *QualifiedContent) Validate() error {
 	return nil
 }
 
+// contentJSON is the JSON form of a QualifiedContent. Exactly one of Text,
+// Value, or Blob is present.
+type contentJSON struct {
+	Type  ContentType     `json:"type"`
+	Text  *string         `json:"text,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+	Blob  *Blob           `json:"blob,omitempty"`
+}
+
+// isCanonicalJSON returns whether b is valid JSON that encoding/json will
+// reproduce exactly when it is embedded within another JSON document.
+func isCanonicalJSON(b []byte) bool {
+	compacted := new(bytes.Buffer)
+	if err := json.Compact(compacted, b); err != nil {
+		return false
+	}
+	escaped := new(bytes.Buffer)
+	json.HTMLEscape(escaped, compacted.Bytes())
+	return bytes.Equal(b, escaped.Bytes())
+}
+
+// MarshalJSON converts the QualifiedContent into a JSON object with its content
+// type and its content. Valid UTF-8 content is written as a "text" string and
+// compact JSON content is embedded directly as a "value". All other content is
+// written as a base64url "blob", so the original bytes can always be recovered.
+func (q *QualifiedContent) MarshalJSON() ([]byte, error) {
+	out := contentJSON{Type: q.Descriptor.Type}
+	switch {
+	case q.Descriptor.Type == ContentTypeUTF8String && utf8.Valid(q.Blob):
+		text := string(q.Blob)
+		out.Text = &text
+	case q.Descriptor.Type == ContentTypeJSON && isCanonicalJSON(q.Blob):
+		out.Value = json.RawMessage(q.Blob)
+	default:
+		out.Blob = &q.Blob
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON parses the JSON form of a QualifiedContent and ensures that it
+// is valid.
+func (q *QualifiedContent) UnmarshalJSON(b []byte) error {
+	var in contentJSON
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	var content []byte
+	switch {
+	case in.Text != nil && in.Value == nil && in.Blob == nil:
+		if in.Type != ContentTypeUTF8String {
+			return fmt.Errorf("Only UTF-8 content can be given as text, got %s content", contentNames[in.Type])
+		}
+		content = []byte(*in.Text)
+	case in.Text == nil && in.Value != nil && in.Blob == nil:
+		if in.Type != ContentTypeJSON {
+			return fmt.Errorf("Only JSON content can be given as a value, got %s content", contentNames[in.Type])
+		}
+		content = []byte(in.Value)
+	case in.Text == nil && in.Value == nil && in.Blob != nil:
+		content = []byte(*in.Blob)
+	default:
+		return fmt.Errorf("Qualified content must have exactly one of text, value, or blob")
+	}
+	qualified, err := NewQualifiedContent(in.Type, content)
+	if err != nil {
+		return err
+	}
+	*q = *qualified
+	return q.Validate()
+}
+
 type QualifiedKey struct {
 	Descriptor KeyDescriptor
 	Blob      Blob
@@ -207,10 +365,7 @@ func (q *QualifiedKey) UnmarshalBinary(b []byte) error {
 	if err != nil {
 		return err
 	}
-	if err := q.Blob.UnmarshalBinary(unused[:q.Descriptor.Length]); err != nil {
-		return err
-	}
-	return nil
+	return unmarshalBinaryBlob(&q.Blob, unused, int(q.Descriptor.Length), len(b)-len(unused))
 }
 
 func (q *QualifiedKey) MarshalBinary() ([]byte, error) {
@@ -229,6 +384,14 @@ func (q *QualifiedKey) MarshalText() ([]byte, error) {
 	return marshalTextQualified(&q.Descriptor, q.Blob)
 }
 
+// UnmarshalText parses the text form of a QualifiedKey and ensures that it is valid.
+func (q *QualifiedKey) UnmarshalText(b []byte) error {
+	if err := unmarshalTextQualified(b, &q.Descriptor, &q.Blob); err != nil {
+		return err
+	}
+	return q.Validate()
+}
+
 func (q *QualifiedKey) Validate() error {
 	if err := q.Descriptor.Validate(); err != nil {
 		return err
@@ -268,10 +431,7 @@ func (q *QualifiedSignature) UnmarshalBinary(b []byte) error {
 	if err != nil {
 		return err
 	}
-	if err := q.Blob.UnmarshalBinary(unused[:q.Descriptor.Length]); err != nil {
-		return err
-	}
-	return nil
+	return unmarshalBinaryBlob(&q.Blob, unused, int(q.Descriptor.Length), len(b)-len(unused))
 }
 
 func (q *QualifiedSignature) MarshalBinary() ([]byte, error) {
@@ -290,6 +450,14 @@ func (q *QualifiedSignature) MarshalText() ([]byte, error) {
 	return marshalTextQualified(&q.Descriptor, q.Blob)
 }
 
+// UnmarshalText parses the text form of a QualifiedSignature and ensures that it is valid.
+func (q *QualifiedSignature) UnmarshalText(b []byte) error {
+	if err := unmarshalTextQualified(b, &q.Descriptor, &q.Blob); err != nil {
+		return err
+	}
+	return q.Validate()
+}
+
 func (q *QualifiedSignature) Validate() error {
 	if err := q.Descriptor.Validate(); err != nil {
 		return err
@@ -1,3 +1,14 @@
 module git.sr.ht/~whereswaldon/forest-go
 
-require golang.org/x/crypto v0.0.0-20190513172903-22d7a77e9e5f
+go 1.18
+
+require (
+	filippo.io/edwards25519 v1.0.0
+	golang.org/x/crypto v0.0.0-20190513172903-22d7a77e9e5f
+)
+
+require (
+	golang.org/x/net v0.0.0-20190404232315-eb5bcb51f2a3 // indirect
+	golang.org/x/sys v0.0.0-20190412213103-97732733099d // indirect
+	golang.org/x/text v0.3.0 // indirect
+)
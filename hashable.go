@@ -1,10 +1,7 @@
 package forest
 
 import (
-	"crypto/sha512"
 	"encoding"
-	"fmt"
-	"hash"
 
 	"git.sr.ht/~whereswaldon/forest-go/fields"
 )
@@ -16,13 +13,6 @@ type Hashable interface {
 
 // computeID determines the correct value of the ID of any hashable entity
 func computeID(h Hashable) ([]byte, error) {
-	// map from HashType and Length to the function that creates an instance of that hash
-	// algorithm
-	hashType2Func := map[fields.HashType]map[fields.ContentLength]func() hash.Hash{
-		fields.HashTypeSHA512: map[fields.ContentLength]func() hash.Hash{
-			fields.HashDigestLengthSHA512_256: sha512.New512_256,
-		},
-	}
 	hd := h.HashDescriptor()
 	if hd.Type == fields.HashTypeNullHash {
 		return []byte{}, nil
@@ -31,13 +21,9 @@ func computeID(h Hashable) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	hashCategory, found := hashType2Func[fields.HashType(hd.Type)]
-	if !found {
-		return nil, fmt.Errorf("Unknown HashType %d", hd.Type)
-	}
-	hashFunc, found := hashCategory[hd.Length]
-	if !found {
-		return nil, fmt.Errorf("Invalid hash length %d for hash type %d", hd.Length, hd.Type)
+	hashFunc, err := fields.HashFunc(hd.Type, hd.Length)
+	if err != nil {
+		return nil, err
 	}
 	hasher := hashFunc()
 	_, _ = hasher.Write(binaryContent) // never errors
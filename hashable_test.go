@@ -0,0 +1,66 @@
+package forest_test
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	forest "git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+const testHashType fields.HashType = 100
+
+func init() {
+	if err := fields.RegisterHashType(testHashType, "SHA256", sha256.Size, sha256.New); err != nil {
+		panic(err)
+	}
+}
+
+func TestBuilderIDHashes(t *testing.T) {
+	identity, signer := MakeIdentityOrSkip(t)
+	hashes := map[fields.HashType]fields.ContentLength{
+		fields.HashTypeSHA512:  fields.HashDigestLengthSHA512_256,
+		fields.HashTypeSHA3:    fields.HashDigestLengthSHA3_256,
+		fields.HashTypeBLAKE2b: fields.HashDigestLengthBLAKE2b_256,
+		testHashType:           sha256.Size,
+	}
+	for hashType, length := range hashes {
+		builder := forest.As(identity, signer).WithIDHash(hashType, length)
+		name := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte("Test Name"))
+		metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+		community, err := builder.NewCommunity(name, metadata)
+		if err != nil {
+			t.Errorf("Failed to create community with hash type %d: %v", hashType, err)
+			continue
+		}
+		reply := newReplyOrSkip(t, builder, community, "test content")
+		for _, node := range []interface {
+			forest.Node
+			forest.Hashable
+		}{community, reply} {
+			id := node.ID()
+			if id.Descriptor.Type != hashType || int(id.Descriptor.Length) != len(id.Blob) || id.Descriptor.Length != length {
+				t.Errorf("Expected ID with hash type %d and length %d, got %v", hashType, length, id.Descriptor)
+			}
+			if err := id.Validate(); err != nil {
+				t.Errorf("ID with hash type %d should be valid: %v", hashType, err)
+			}
+			if correct, err := forest.ValidateID(node, *id); err != nil || !correct {
+				t.Errorf("ID validation failed with hash type %d: %v", hashType, err)
+			}
+		}
+		ensureSerializes(t, reply)
+	}
+}
+
+func TestBuilderRejectsInvalidIDHash(t *testing.T) {
+	identity, signer := MakeIdentityOrSkip(t)
+	name := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte("Test Name"))
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	if _, err := forest.As(identity, signer).WithIDHash(fields.HashTypeSHA3, 64).NewCommunity(name, metadata); err == nil {
+		t.Error("Builder should reject unsupported hash lengths")
+	}
+	if _, err := forest.As(identity, signer).WithIDHash(fields.HashTypeNullHash, 0).NewCommunity(name, metadata); err == nil {
+		t.Error("Builder should reject the null hash")
+	}
+}
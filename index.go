@@ -0,0 +1,264 @@
+package forest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// Query describes a search over the secondary indices of a QueryStore. Every
+// filter that is not nil must match a node for it to be included in the
+// results, and at least one filter must be provided.
+//
+// Results are ordered by the Depth of each node and then by the text form of
+// its ID, so paging through a query with Cursor is stable even when nodes are
+// added between pages.
+type Query struct {
+	// Author matches nodes signed by the Identity with this ID.
+	Author *fields.QualifiedHash
+	// CommunityID matches replies within the Community with this ID.
+	CommunityID *fields.QualifiedHash
+	// ConversationID matches replies within the conversation rooted at the
+	// reply with this ID. The root reply itself is not matched.
+	ConversationID *fields.QualifiedHash
+	// Target matches nodes that refer to the node with this ID, like an Edit
+	// of a Reply.
+	Target *fields.QualifiedHash
+	// Cursor resumes a query after the last node of a previous page. It must
+	// either be empty or the Next value of a previous QueryPage.
+	Cursor string
+	// Limit is the maximum number of nodes in a page. Zero means no limit.
+	Limit int
+}
+
+// QueryPage is a single page of the results of a Query.
+type QueryPage struct {
+	Nodes []Node
+	// Next is the cursor to use to request the following page. It is empty if
+	// there are no more results.
+	Next string
+}
+
+// queryCursor identifies a position within the ordered results of a query.
+type queryCursor struct {
+	depth fields.TreeDepth
+	id    string
+}
+
+func (c queryCursor) String() string {
+	depth, _ := c.depth.MarshalText()
+	return string(depth) + "_" + c.id
+}
+
+func (c queryCursor) before(other queryCursor) bool {
+	if c.depth != other.depth {
+		return c.depth < other.depth
+	}
+	return c.id < other.id
+}
+
+func parseQueryCursor(s string) (queryCursor, error) {
+	parts := strings.SplitN(s, "_", 2)
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "L") {
+		return queryCursor{}, fmt.Errorf("Invalid query cursor %q", s)
+	}
+	depth, err := strconv.ParseUint(parts[0][1:], 10, 32)
+	if err != nil {
+		return queryCursor{}, fmt.Errorf("Invalid query cursor depth in %q: %v", s, err)
+	}
+	return queryCursor{depth: fields.TreeDepth(depth), id: parts[1]}, nil
+}
+
+// idSet is a set of the text form of node IDs
+type idSet map[string]struct{}
+
+// nodeIndex maintains lookup tables over the relationships between nodes so
+// that stores can answer structural queries without scanning every node.
+// It is updated incrementally as nodes are added to a store.
+type nodeIndex struct {
+	// children maps the text form of a node's ID to the IDs of its children
+	children map[string][]*fields.QualifiedHash
+	// depths holds the depth of every indexed node
+	depths map[string]fields.TreeDepth
+	// the following map the text form of an ID to the nodes that reference it
+	// in the corresponding field
+	authors       map[string]idSet
+	communities   map[string]idSet
+	conversations map[string]idSet
+	targets       map[string]idSet
+}
+
+// targetedNode is a node that refers to another node without being its child.
+type targetedNode interface {
+	TargetID() *fields.QualifiedHash
+}
+
+func newNodeIndex() *nodeIndex {
+	return &nodeIndex{
+		children:      make(map[string][]*fields.QualifiedHash),
+		depths:        make(map[string]fields.TreeDepth),
+		authors:       make(map[string]idSet),
+		communities:   make(map[string]idSet),
+		conversations: make(map[string]idSet),
+		targets:       make(map[string]idSet),
+	}
+}
+
+// addTo records id in the set for key within table, unless key is the null hash.
+func addTo(table map[string]idSet, key *fields.QualifiedHash, id string) error {
+	if key.Equals(fields.NullHash()) {
+		return nil
+	}
+	keyString, err := key.MarshalString()
+	if err != nil {
+		return err
+	}
+	set, ok := table[keyString]
+	if !ok {
+		set = make(idSet)
+		table[keyString] = set
+	}
+	set[id] = struct{}{}
+	return nil
+}
+
+// add records the relationships of the given node. It must only be invoked
+// once for each node.
+func (x *nodeIndex) add(node Node) error {
+	id, err := node.ID().MarshalString()
+	if err != nil {
+		return err
+	}
+	if common, ok := node.(interface{ common() *commonNode }); ok {
+		c := common.common()
+		x.depths[id] = c.Depth
+		if err := addTo(x.authors, &c.Author, id); err != nil {
+			return err
+		}
+	}
+	if reply, ok := node.(*Reply); ok {
+		if err := addTo(x.communities, &reply.CommunityID, id); err != nil {
+			return err
+		}
+		if err := addTo(x.conversations, &reply.ConversationID, id); err != nil {
+			return err
+		}
+	}
+	if targeted, ok := node.(targetedNode); ok {
+		if err := addTo(x.targets, targeted.TargetID(), id); err != nil {
+			return err
+		}
+	}
+	parent := node.ParentID()
+	if parent.Equals(fields.NullHash()) {
+		return nil
+	}
+	parentID, err := parent.MarshalString()
+	if err != nil {
+		return err
+	}
+	x.children[parentID] = append(x.children[parentID], node.ID())
+	return nil
+}
+
+// Children returns the IDs of all known children of the node with the given ID.
+func (x *nodeIndex) Children(id *fields.QualifiedHash) ([]*fields.QualifiedHash, error) {
+	idString, err := id.MarshalString()
+	if err != nil {
+		return nil, err
+	}
+	known := x.children[idString]
+	out := make([]*fields.QualifiedHash, len(known))
+	copy(out, known)
+	return out, nil
+}
+
+// query returns the text form of the IDs of the nodes in the requested page of
+// results as well as the cursor for the next page.
+func (x *nodeIndex) query(q *Query) ([]string, string, error) {
+	var candidates []idSet
+	filters := []struct {
+		key   *fields.QualifiedHash
+		table map[string]idSet
+	}{
+		{q.Author, x.authors},
+		{q.CommunityID, x.communities},
+		{q.ConversationID, x.conversations},
+		{q.Target, x.targets},
+	}
+	for _, filter := range filters {
+		if filter.key == nil {
+			continue
+		}
+		keyString, err := filter.key.MarshalString()
+		if err != nil {
+			return nil, "", err
+		}
+		candidates = append(candidates, filter.table[keyString])
+	}
+	if len(candidates) == 0 {
+		return nil, "", fmt.Errorf("Query must specify at least one filter")
+	}
+	var after *queryCursor
+	if q.Cursor != "" {
+		cursor, err := parseQueryCursor(q.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		after = &cursor
+	}
+	// iterate the smallest set and check membership in the others
+	sort.Slice(candidates, func(i, j int) bool {
+		return len(candidates[i]) < len(candidates[j])
+	})
+	var matches []queryCursor
+outer:
+	for id := range candidates[0] {
+		for _, other := range candidates[1:] {
+			if _, ok := other[id]; !ok {
+				continue outer
+			}
+		}
+		match := queryCursor{depth: x.depths[id], id: id}
+		if after != nil && !after.before(match) {
+			continue
+		}
+		matches = append(matches, match)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].before(matches[j])
+	})
+	next := ""
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[:q.Limit]
+		next = matches[len(matches)-1].String()
+	}
+	ids := make([]string, len(matches))
+	for i, match := range matches {
+		ids[i] = match.id
+	}
+	return ids, next, nil
+}
+
+// queryStore resolves the results of a query over the index into nodes
+// by looking each of them up with get.
+func (x *nodeIndex) queryStore(q *Query, get func(string) (Node, bool, error)) (*QueryPage, error) {
+	ids, next, err := x.query(q)
+	if err != nil {
+		return nil, err
+	}
+	page := &QueryPage{Nodes: make([]Node, 0, len(ids)), Next: next}
+	for _, id := range ids {
+		node, has, err := get(id)
+		if err != nil {
+			return nil, err
+		} else if !has {
+			return nil, fmt.Errorf("Index references missing node %s", id)
+		}
+		page.Nodes = append(page.Nodes, node)
+	}
+	return page, nil
+}
@@ -0,0 +1,278 @@
+package forest
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// nodeJSON is the JSON form of the fields shared by every node type.
+//
+// IDs and other hashes use their text form, like "SHA512_B32__<base64url>",
+// keys and signatures use the same "<type>_B<length>__<base64url>" form, and
+// content is an object described by fields.QualifiedContent.MarshalJSON. The
+// descriptor of the node's ID is part of the text form of "id".
+type nodeJSON struct {
+	ID            *fields.QualifiedHash      `json:"id"`
+	Type          fields.NodeType            `json:"type"`
+	SchemaVersion uint64                     `json:"schemaVersion"`
+	Parent        *fields.QualifiedHash      `json:"parent"`
+	Depth         uint32                     `json:"depth"`
+	Metadata      *fields.QualifiedContent   `json:"metadata"`
+	Author        *fields.QualifiedHash      `json:"author"`
+	Created       uint64                     `json:"created,omitempty"`
+	Signature     *fields.QualifiedSignature `json:"signature"`
+}
+
+type identityJSON struct {
+	nodeJSON
+	Name      *fields.QualifiedContent `json:"name"`
+	PublicKey *fields.QualifiedKey     `json:"publicKey"`
+}
+
+type communityJSON struct {
+	nodeJSON
+	Name *fields.QualifiedContent `json:"name"`
+}
+
+type replyJSON struct {
+	nodeJSON
+	CommunityID    *fields.QualifiedHash    `json:"communityID"`
+	ConversationID *fields.QualifiedHash    `json:"conversationID"`
+	Content        *fields.QualifiedContent `json:"content"`
+}
+
+func (n *commonNode) toJSON() nodeJSON {
+	return nodeJSON{
+		ID:            n.ID(),
+		Type:          n.Type,
+		SchemaVersion: uint64(n.SchemaVersion),
+		Parent:        &n.Parent,
+		Depth:         uint32(n.Depth),
+		Metadata:      &n.Metadata,
+		Author:        &n.Author,
+		Created:       uint64(n.Created),
+		Signature:     &n.Signature,
+	}
+}
+
+// jsonField records whether a required field was present within a JSON node.
+type jsonField struct {
+	name    string
+	present bool
+}
+
+// requireJSONFields returns an error naming the first of the fields that was
+// missing from a JSON node.
+func requireJSONFields(required []jsonField) error {
+	for _, field := range required {
+		if !field.present {
+			return fmt.Errorf("JSON node is missing required field %q", field.name)
+		}
+	}
+	return nil
+}
+
+// fromJSON populates the common fields of the node from their JSON form.
+func (n *commonNode) fromJSON(in *nodeJSON, expected fields.NodeType) error {
+	if err := requireJSONFields([]jsonField{
+		{"id", in.ID != nil},
+		{"parent", in.Parent != nil},
+		{"metadata", in.Metadata != nil},
+		{"author", in.Author != nil},
+		{"signature", in.Signature != nil},
+	}); err != nil {
+		return err
+	}
+	if in.Type != expected {
+		return fmt.Errorf("Expected node of type %d, got %d", expected, in.Type)
+	}
+	n.Type = in.Type
+	n.SchemaVersion = fields.Version(in.SchemaVersion)
+	s, err := schemaFor(n.SchemaVersion)
+	if err != nil {
+		return err
+	}
+	// the creation time is not covered by the ID or signature of nodes that
+	// do not record it, so it cannot be trusted
+	if !s.timestamps && in.Created != 0 {
+		return fmt.Errorf("Schema version %d does not record creation times, but the JSON node has one", n.SchemaVersion)
+	}
+	n.Parent = *in.Parent
+	n.IDDesc = in.ID.Descriptor
+	n.Depth = fields.TreeDepth(in.Depth)
+	n.Metadata = *in.Metadata
+	n.Author = *in.Author
+	n.Created = fields.Timestamp(in.Created)
+	n.Signature = *in.Signature
+	return nil
+}
+
+// finishJSON computes the ID of a node decoded from JSON and ensures that it
+// matches the ID that the JSON claimed.
+func finishJSON(h Hashable, n *commonNode, claimed *fields.QualifiedHash) error {
+	id, err := computeID(h)
+	if err != nil {
+		return err
+	}
+	n.id = fields.Blob(id)
+	if !n.ID().Equals(claimed) {
+		return fmt.Errorf("JSON node claims ID %v, but its contents have ID %v", claimed, n.ID())
+	}
+	return nil
+}
+
+// MarshalJSON converts the Identity into its canonical JSON form.
+func (i *Identity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(identityJSON{
+		nodeJSON:  i.commonNode.toJSON(),
+		Name:      &i.Name,
+		PublicKey: &i.PublicKey,
+	})
+}
+
+// UnmarshalJSON populates the Identity from its canonical JSON form. It fails
+// unless the ID within the JSON matches the Identity's contents and the
+// Identity is correctly signed by its own key.
+func (i *Identity) UnmarshalJSON(b []byte) error {
+	var in identityJSON
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	if err := requireJSONFields([]jsonField{
+		{"name", in.Name != nil},
+		{"publicKey", in.PublicKey != nil},
+	}); err != nil {
+		return err
+	}
+	if err := i.commonNode.fromJSON(&in.nodeJSON, fields.NodeTypeIdentity); err != nil {
+		return err
+	}
+	i.Name = *in.Name
+	i.PublicKey = *in.PublicKey
+	if err := finishJSON(i, &i.commonNode, in.ID); err != nil {
+		return err
+	}
+	if valid, err := ValidateSignature(i, i); err != nil {
+		return err
+	} else if !valid {
+		return fmt.Errorf("Identity %v is not signed by its own key", i.ID())
+	}
+	return nil
+}
+
+// MarshalJSON converts the Community into its canonical JSON form.
+func (c *Community) MarshalJSON() ([]byte, error) {
+	return json.Marshal(communityJSON{
+		nodeJSON: c.commonNode.toJSON(),
+		Name:     &c.Name,
+	})
+}
+
+// UnmarshalJSON populates the Community from its canonical JSON form. It fails
+// unless the ID within the JSON matches the Community's contents. Its signature
+// cannot be checked without its author, so use UnmarshalJSONNode to decode
+// untrusted nodes.
+func (c *Community) UnmarshalJSON(b []byte) error {
+	var in communityJSON
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	if err := requireJSONFields([]jsonField{
+		{"name", in.Name != nil},
+	}); err != nil {
+		return err
+	}
+	if err := c.commonNode.fromJSON(&in.nodeJSON, fields.NodeTypeCommunity); err != nil {
+		return err
+	}
+	c.Name = *in.Name
+	return finishJSON(c, &c.commonNode, in.ID)
+}
+
+// MarshalJSON converts the Reply into its canonical JSON form.
+func (r *Reply) MarshalJSON() ([]byte, error) {
+	return json.Marshal(replyJSON{
+		nodeJSON:       r.commonNode.toJSON(),
+		CommunityID:    &r.CommunityID,
+		ConversationID: &r.ConversationID,
+		Content:        &r.Content,
+	})
+}
+
+// UnmarshalJSON populates the Reply from its canonical JSON form. It fails
+// unless the ID within the JSON matches the Reply's contents. Its signature
+// cannot be checked without its author, so use UnmarshalJSONNode to decode
+// untrusted nodes.
+func (r *Reply) UnmarshalJSON(b []byte) error {
+	var in replyJSON
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	if err := requireJSONFields([]jsonField{
+		{"communityID", in.CommunityID != nil},
+		{"conversationID", in.ConversationID != nil},
+		{"content", in.Content != nil},
+	}); err != nil {
+		return err
+	}
+	if err := r.commonNode.fromJSON(&in.nodeJSON, fields.NodeTypeReply); err != nil {
+		return err
+	}
+	r.CommunityID = *in.CommunityID
+	r.ConversationID = *in.ConversationID
+	r.Content = *in.Content
+	return finishJSON(r, &r.commonNode, in.ID)
+}
+
+// UnmarshalJSONNode decodes a node of any type from its canonical JSON form and
+// validates its signature. The author of the node is looked up within the
+// store, so it must be present unless the node is an Identity. If the store is
+// a QueryStore, the author's KeyHistory is used to choose the key. If it does
+// not return an error, the concrete type of the returned node will be one of
+// the node structs declared in this package or a type added with
+// RegisterNodeType.
+func UnmarshalJSONNode(b []byte, store Store) (Node, error) {
+	var header struct {
+		Type fields.NodeType `json:"type"`
+	}
+	if err := json.Unmarshal(b, &header); err != nil {
+		return nil, err
+	}
+	empty, err := NewNode(header.Type)
+	if err != nil {
+		return nil, err
+	}
+	unmarshaler, ok := empty.(json.Unmarshaler)
+	if !ok {
+		return nil, fmt.Errorf("Node of type %T cannot be unmarshaled from JSON", empty)
+	}
+	if err := unmarshaler.UnmarshalJSON(b); err != nil {
+		return nil, err
+	}
+	if identity, ok := empty.(*Identity); ok {
+		// identities validate their own signature while unmarshaling
+		return identity, nil
+	}
+	node, ok := empty.(signedNode)
+	if !ok {
+		return nil, fmt.Errorf("Node of type %T cannot have its signature validated", empty)
+	}
+	authorNode, has, err := store.Get(node.SignatureIdentityHash())
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, fmt.Errorf("Missing author node %v", node.SignatureIdentityHash())
+	}
+	author, ok := authorNode.(*Identity)
+	if !ok {
+		return nil, fmt.Errorf("Author must be an identity, got %T", authorNode)
+	}
+	if valid, err := validateSignatureIn(store, node, author); err != nil {
+		return nil, err
+	} else if !valid {
+		return nil, fmt.Errorf("Node %v is not signed by its author", node.ID())
+	}
+	return node, nil
+}
@@ -0,0 +1,180 @@
+package forest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	forest "git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	identity, _, community, reply := MakeReplyOrSkip(t)
+	store := forest.NewMemoryStore()
+	if err := store.Add(identity); err != nil {
+		t.Skip("Failed to add identity", err)
+	}
+	for _, node := range []forest.Node{identity, community, reply} {
+		text, err := json.Marshal(node)
+		if err != nil {
+			t.Errorf("Failed to marshal %T to JSON: %v", node, err)
+			continue
+		}
+		decoded, err := forest.UnmarshalJSONNode(text, store)
+		if err != nil {
+			t.Errorf("Failed to unmarshal %T from JSON %s: %v", node, text, err)
+			continue
+		}
+		if !decoded.Equals(node) || !decoded.ID().Equals(node.ID()) {
+			t.Errorf("Node changed during JSON round trip")
+		}
+		original, _ := node.MarshalBinary()
+		roundTripped, _ := decoded.MarshalBinary()
+		if !bytes.Equal(original, roundTripped) {
+			t.Errorf("Binary form of %T changed during JSON round trip", node)
+		}
+	}
+}
+
+func TestJSONIsHumanReadable(t *testing.T) {
+	identity, _, _, reply := MakeValidReplyOrSkip(t)
+	text, err := json.Marshal(reply)
+	if err != nil {
+		t.Fatalf("Failed to marshal reply: %v", err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(text, &decoded); err != nil {
+		t.Fatalf("Failed to parse JSON: %v", err)
+	}
+	authorID, _ := identity.ID().MarshalString()
+	expected := map[string]interface{}{
+		"type":   "reply",
+		"author": authorID,
+		"depth":  float64(1),
+		"content": map[string]interface{}{
+			"type": "UTF-8",
+			"text": "test content",
+		},
+		"metadata": map[string]interface{}{
+			"type":  "JSON",
+			"value": map[string]interface{}{},
+		},
+	}
+	for key, value := range expected {
+		actual, _ := json.Marshal(decoded[key])
+		wanted, _ := json.Marshal(value)
+		if !bytes.Equal(actual, wanted) {
+			t.Errorf("Expected %q to be %s, got %s", key, wanted, actual)
+		}
+	}
+}
+
+func TestJSONContentFallsBackToBlob(t *testing.T) {
+	for _, test := range []struct {
+		contentType fields.ContentType
+		content     string
+		key         string
+	}{
+		{fields.ContentTypeUTF8String, "hello", `"text"`},
+		{fields.ContentTypeUTF8String, "\xff", `"blob"`},
+		{fields.ContentTypeJSON, `{"a":[1,2]}`, `"value"`},
+		{fields.ContentTypeJSON, `{ "a": 1 }`, `"blob"`},
+		{fields.ContentTypeJSON, `"<b>"`, `"blob"`},
+		{fields.ContentTypeJSON, ``, `"blob"`},
+	} {
+		content := QualifiedContentOrSkip(t, test.contentType, []byte(test.content))
+		text, err := json.Marshal(content)
+		if err != nil {
+			t.Errorf("Failed to marshal content %q: %v", test.content, err)
+			continue
+		}
+		if !strings.Contains(string(text), test.key) {
+			t.Errorf("Expected content %q to be marshaled as %s, got %s", test.content, test.key, text)
+		}
+		decoded := new(fields.QualifiedContent)
+		if err := json.Unmarshal(text, decoded); err != nil {
+			t.Errorf("Failed to unmarshal content %s: %v", text, err)
+		} else if !decoded.Equals(content) {
+			t.Errorf("Content %q changed during JSON round trip to %q", test.content, decoded.Blob)
+		}
+	}
+}
+
+func TestJSONRejectsTamperedNodes(t *testing.T) {
+	identity, _, community, reply := MakeReplyOrSkip(t)
+	store := forest.NewMemoryStore()
+	if err := store.Add(identity); err != nil {
+		t.Skip("Failed to add identity", err)
+	}
+	text, err := json.Marshal(reply)
+	if err != nil {
+		t.Fatalf("Failed to marshal reply: %v", err)
+	}
+	tampered := bytes.Replace(text, []byte("test content"), []byte("TEST CONTENT"), 1)
+	if _, err := forest.UnmarshalJSONNode(tampered, store); err == nil {
+		t.Errorf("Unmarshaling a reply with modified content should fail")
+	}
+	// a reply whose author is unknown cannot have its signature checked
+	if _, err := forest.UnmarshalJSONNode(text, forest.NewMemoryStore()); err == nil {
+		t.Errorf("Unmarshaling a reply with an unknown author should fail")
+	}
+	// a node with a valid ID but the wrong signature
+	_, otherSigner := MakeIdentityOrSkip(t)
+	forged, err := forest.As(identity, otherSigner).NewReply(community, &reply.Content, &reply.Metadata)
+	if err != nil {
+		t.Skip("Failed to create forged reply", err)
+	}
+	if text, err = json.Marshal(forged); err != nil {
+		t.Fatalf("Failed to marshal reply: %v", err)
+	}
+	if _, err := forest.UnmarshalJSONNode(text, store); err == nil {
+		t.Errorf("Unmarshaling a reply with a forged signature should fail")
+	}
+	if err := json.Unmarshal(text, new(forest.Identity)); err == nil {
+		t.Errorf("Unmarshaling into the wrong type should fail")
+	}
+	var missing forest.Reply
+	if err := json.Unmarshal([]byte(`{"type":"reply"}`), &missing); err == nil {
+		t.Errorf("Unmarshaling a reply without required fields should fail")
+	}
+}
+
+func TestJSONRejectsUnsupportedVersions(t *testing.T) {
+	identity, _, community, _ := MakeReplyOrSkip(t)
+	store := forest.NewMemoryStore()
+	if err := store.Add(identity); err != nil {
+		t.Skip("Failed to add identity", err)
+	}
+	text, err := json.Marshal(community)
+	if err != nil {
+		t.Fatalf("Failed to marshal community: %v", err)
+	}
+	version := fmt.Sprintf(`"schemaVersion":%d`, community.SchemaVersion)
+	unsupported := bytes.Replace(text, []byte(version), []byte(`"schemaVersion":99`), 1)
+	if _, err := forest.UnmarshalJSONNode(unsupported, store); err == nil || !strings.Contains(err.Error(), "Unsupported schema version") {
+		t.Errorf("Unmarshaling a node with an unsupported schema version should fail, got %v", err)
+	}
+}
+
+func TestJSONRejectsCreationTimeWithoutTimestamps(t *testing.T) {
+	nodes := makeVersionedNodesOrSkip(t, fields.VersionInitial, fields.VersionInitial)
+	store := forest.NewMemoryStore()
+	if err := store.Add(nodes[0]); err != nil {
+		t.Skip("Failed to add identity", err)
+	}
+	text, err := json.Marshal(nodes[1])
+	if err != nil {
+		t.Fatalf("Failed to marshal community: %v", err)
+	}
+	if _, err := forest.UnmarshalJSONNode(text, store); err != nil {
+		t.Fatalf("Failed to unmarshal version %d community: %v", fields.VersionInitial, err)
+	}
+	// the creation time is not covered by the ID of a version 1 node
+	created := bytes.Replace(text, []byte(`"signature":`), []byte(`"created":12345,"signature":`), 1)
+	if _, err := forest.UnmarshalJSONNode(created, store); err == nil {
+		t.Errorf("Unmarshaling a version %d node with a creation time should fail", fields.VersionInitial)
+	}
+}
@@ -0,0 +1,543 @@
+package forest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// KeyRotation nodes replace the key used to sign the nodes of an Identity.
+// Each KeyRotation names the previous key record of the Identity, which is
+// either the Identity itself or an earlier KeyRotation, and must be signed by
+// the key that it replaces. A revoked key cannot be rotated, so a KeyRotation
+// must be added to a store before any KeyRevocation of the key it replaces.
+// The new key is used for nodes created at or after the creation time of the
+// KeyRotation, so KeyRotations must be written with a schema version that
+// records creation times. Like an Edit, a KeyRotation has no parent and a
+// depth of zero. Use LoadKeyHistory to follow the keys of an Identity.
+type KeyRotation struct {
+	commonNode
+	Previous  fields.QualifiedHash
+	PublicKey fields.QualifiedKey
+}
+
+func newKeyRotation() *KeyRotation {
+	k := new(KeyRotation)
+	// define how to serialize this node type's fields
+	return k
+}
+
+// TargetID returns the ID of the Identity whose key is rotated by this node.
+func (k *KeyRotation) TargetID() *fields.QualifiedHash {
+	return &k.Author
+}
+
+// signingRecord returns the ID of the key record holding the key that signed
+// this node.
+func (k *KeyRotation) signingRecord() *fields.QualifiedHash {
+	return &k.Previous
+}
+
+func (k *KeyRotation) nodeSpecificSerializationOrder() []fields.BidirectionalBinaryMarshaler {
+	return []fields.BidirectionalBinaryMarshaler{&k.Previous, &k.PublicKey}
+}
+
+func (k *KeyRotation) SerializationOrder() []fields.BidirectionalBinaryMarshaler {
+	order := k.commonNode.presignSerializationOrder()
+	order = append(order, k.nodeSpecificSerializationOrder()...)
+	order = append(order, k.commonNode.postsignSerializationOrder()...)
+	return order
+}
+
+func (k KeyRotation) MarshalSignedData() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(k.presignSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(k.nodeSpecificSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (k KeyRotation) MarshalBinary() ([]byte, error) {
+	signed, err := k.MarshalSignedData()
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(signed)
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(k.postsignSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func UnmarshalKeyRotation(b []byte) (*KeyRotation, error) {
+	k := newKeyRotation()
+	if err := k.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *KeyRotation) UnmarshalBinary(b []byte) error {
+	if err := k.unmarshalBinaryVersion(b); err != nil {
+		return err
+	}
+	if err := UnmarshalBinary(k, b); err != nil {
+		return err
+	}
+	idBytes, err := computeID(k)
+	if err != nil {
+		return err
+	}
+	k.id = fields.Blob(idBytes)
+	return nil
+}
+
+func (k *KeyRotation) Equals(other interface{}) bool {
+	k2, valid := other.(*KeyRotation)
+	if !valid {
+		return false
+	}
+	return k.commonNode.Equals(&k2.commonNode) &&
+		k.Previous.Equals(&k2.Previous) &&
+		k.PublicKey.Equals(&k2.PublicKey)
+}
+
+// ValidateShallow checks all fields for internal validity. It does not check
+// the existence or validity of nodes referenced from this node.
+func (k *KeyRotation) ValidateShallow() error {
+	if err := k.commonNode.ValidateShallow(); err != nil {
+		return err
+	}
+	needsValidation := []Validator{&k.Previous, &k.PublicKey}
+	for _, nv := range needsValidation {
+		if err := nv.Validate(); err != nil {
+			return err
+		}
+	}
+	if s, err := schemaFor(k.SchemaVersion); err != nil || !s.timestamps {
+		return fmt.Errorf("Key rotations must record their creation time, which schema version %d does not", k.SchemaVersion)
+	}
+	if !k.Parent.Equals(fields.NullHash()) {
+		return fmt.Errorf("Key rotation parent must be null hash, got %v", k.Parent)
+	}
+	if k.Depth != 0 {
+		return fmt.Errorf("Key rotation depth must be 0, got %d", k.Depth)
+	}
+	if k.Previous.Equals(fields.NullHash()) {
+		return fmt.Errorf("Key rotation previous key record must not be null hash")
+	}
+	if k.Author.Equals(fields.NullHash()) {
+		return fmt.Errorf("Key rotation author must not be null hash")
+	}
+	return nil
+}
+
+// ValidateDeep checks that the author and the previous key record exist
+// within the store, and that the previous key record belongs to the author.
+func (k *KeyRotation) ValidateDeep(store Store) error {
+	previous, err := getKeyRecord(store, &k.Previous, &k.Author)
+	if err != nil {
+		return err
+	}
+	return k.validateCreatedAfter(previous)
+}
+
+// KeyRevocation nodes withdraw one of the keys of an Identity, so that nodes
+// signed by that key are no longer valid. A KeyRevocation names the key
+// record that introduced the revoked key, which is either the Identity itself
+// or one of its KeyRotations, and must be signed by the revoked key. Like an
+// Edit, a KeyRevocation has no parent and a depth of zero.
+type KeyRevocation struct {
+	commonNode
+	Revoked fields.QualifiedHash
+}
+
+func newKeyRevocation() *KeyRevocation {
+	k := new(KeyRevocation)
+	// define how to serialize this node type's fields
+	return k
+}
+
+// TargetID returns the ID of the Identity whose key is revoked by this node.
+func (k *KeyRevocation) TargetID() *fields.QualifiedHash {
+	return &k.Author
+}
+
+// signingRecord returns the ID of the key record holding the key that signed
+// this node.
+func (k *KeyRevocation) signingRecord() *fields.QualifiedHash {
+	return &k.Revoked
+}
+
+func (k *KeyRevocation) nodeSpecificSerializationOrder() []fields.BidirectionalBinaryMarshaler {
+	return []fields.BidirectionalBinaryMarshaler{&k.Revoked}
+}
+
+func (k *KeyRevocation) SerializationOrder() []fields.BidirectionalBinaryMarshaler {
+	order := k.commonNode.presignSerializationOrder()
+	order = append(order, k.nodeSpecificSerializationOrder()...)
+	order = append(order, k.commonNode.postsignSerializationOrder()...)
+	return order
+}
+
+func (k KeyRevocation) MarshalSignedData() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(k.presignSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(k.nodeSpecificSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (k KeyRevocation) MarshalBinary() ([]byte, error) {
+	signed, err := k.MarshalSignedData()
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(signed)
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(k.postsignSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func UnmarshalKeyRevocation(b []byte) (*KeyRevocation, error) {
+	k := newKeyRevocation()
+	if err := k.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *KeyRevocation) UnmarshalBinary(b []byte) error {
+	if err := k.unmarshalBinaryVersion(b); err != nil {
+		return err
+	}
+	if err := UnmarshalBinary(k, b); err != nil {
+		return err
+	}
+	idBytes, err := computeID(k)
+	if err != nil {
+		return err
+	}
+	k.id = fields.Blob(idBytes)
+	return nil
+}
+
+func (k *KeyRevocation) Equals(other interface{}) bool {
+	k2, valid := other.(*KeyRevocation)
+	if !valid {
+		return false
+	}
+	return k.commonNode.Equals(&k2.commonNode) &&
+		k.Revoked.Equals(&k2.Revoked)
+}
+
+// ValidateShallow checks all fields for internal validity. It does not check
+// the existence or validity of nodes referenced from this node.
+func (k *KeyRevocation) ValidateShallow() error {
+	if err := k.commonNode.ValidateShallow(); err != nil {
+		return err
+	}
+	if err := k.Revoked.Validate(); err != nil {
+		return err
+	}
+	if !k.Parent.Equals(fields.NullHash()) {
+		return fmt.Errorf("Key revocation parent must be null hash, got %v", k.Parent)
+	}
+	if k.Depth != 0 {
+		return fmt.Errorf("Key revocation depth must be 0, got %d", k.Depth)
+	}
+	if k.Revoked.Equals(fields.NullHash()) {
+		return fmt.Errorf("Key revocation revoked key record must not be null hash")
+	}
+	if k.Author.Equals(fields.NullHash()) {
+		return fmt.Errorf("Key revocation author must not be null hash")
+	}
+	return nil
+}
+
+// ValidateDeep checks that the author and the revoked key record exist within
+// the store, and that the revoked key record belongs to the author.
+func (k *KeyRevocation) ValidateDeep(store Store) error {
+	_, err := getKeyRecord(store, &k.Revoked, &k.Author)
+	return err
+}
+
+// getKeyRecord looks up the key record with the given ID, ensuring that it is
+// either the Identity with the given ID or one of that Identity's KeyRotations.
+func getKeyRecord(store Store, id, identity *fields.QualifiedHash) (Node, error) {
+	if _, has, err := store.Get(identity); !has {
+		return nil, fmt.Errorf("Missing required node %v", identity)
+	} else if err != nil {
+		return nil, err
+	}
+	record, has, err := store.Get(id)
+	if !has {
+		return nil, fmt.Errorf("Missing required node %v", id)
+	} else if err != nil {
+		return nil, err
+	}
+	switch r := record.(type) {
+	case *Identity:
+		if !r.ID().Equals(identity) {
+			return nil, fmt.Errorf("Key record %v is a different identity", id)
+		}
+	case *KeyRotation:
+		if !r.Author.Equals(identity) {
+			return nil, fmt.Errorf("Key record %v belongs to a different identity", id)
+		}
+	default:
+		return nil, fmt.Errorf("Key record must be an identity or a key rotation, got %T", record)
+	}
+	return record, nil
+}
+
+// isKeyRecordOf returns whether the node introduced a key of the identity,
+// either because it is the Identity or because it is one of its KeyRotations.
+func isKeyRecordOf(node Node, identity *Identity) bool {
+	switch n := node.(type) {
+	case *Identity:
+		return n.ID().Equals(identity.ID())
+	case *KeyRotation:
+		return n.Author.Equals(identity.ID())
+	}
+	return false
+}
+
+type keyRotationJSON struct {
+	nodeJSON
+	Previous  *fields.QualifiedHash `json:"previous"`
+	PublicKey *fields.QualifiedKey  `json:"publicKey"`
+}
+
+// MarshalJSON converts the KeyRotation into its canonical JSON form.
+func (k *KeyRotation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(keyRotationJSON{
+		nodeJSON:  k.commonNode.toJSON(),
+		Previous:  &k.Previous,
+		PublicKey: &k.PublicKey,
+	})
+}
+
+// UnmarshalJSON populates the KeyRotation from its canonical JSON form. It
+// fails unless the ID within the JSON matches the KeyRotation's contents. Its
+// signature cannot be checked without its previous key, so use
+// UnmarshalJSONNode to decode untrusted nodes.
+func (k *KeyRotation) UnmarshalJSON(b []byte) error {
+	var in keyRotationJSON
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	if err := requireJSONFields([]jsonField{
+		{"previous", in.Previous != nil},
+		{"publicKey", in.PublicKey != nil},
+	}); err != nil {
+		return err
+	}
+	if err := k.commonNode.fromJSON(&in.nodeJSON, fields.NodeTypeKeyRotation); err != nil {
+		return err
+	}
+	k.Previous = *in.Previous
+	k.PublicKey = *in.PublicKey
+	return finishJSON(k, &k.commonNode, in.ID)
+}
+
+type keyRevocationJSON struct {
+	nodeJSON
+	Revoked *fields.QualifiedHash `json:"revoked"`
+}
+
+// MarshalJSON converts the KeyRevocation into its canonical JSON form.
+func (k *KeyRevocation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(keyRevocationJSON{
+		nodeJSON: k.commonNode.toJSON(),
+		Revoked:  &k.Revoked,
+	})
+}
+
+// UnmarshalJSON populates the KeyRevocation from its canonical JSON form. It
+// fails unless the ID within the JSON matches the KeyRevocation's contents.
+// Its signature cannot be checked without the revoked key, so use
+// UnmarshalJSONNode to decode untrusted nodes.
+func (k *KeyRevocation) UnmarshalJSON(b []byte) error {
+	var in keyRevocationJSON
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	if err := requireJSONFields([]jsonField{
+		{"revoked", in.Revoked != nil},
+	}); err != nil {
+		return err
+	}
+	if err := k.commonNode.fromJSON(&in.nodeJSON, fields.NodeTypeKeyRevocation); err != nil {
+		return err
+	}
+	k.Revoked = *in.Revoked
+	return finishJSON(k, &k.commonNode, in.ID)
+}
+
+// keyRecordSigned is implemented by the nodes that are signed by the key of
+// a particular key record rather than the key in use when they were created.
+type keyRecordSigned interface {
+	signingRecord() *fields.QualifiedHash
+}
+
+// KeyPeriod describes one of the keys of an Identity and the period during
+// which it was used.
+type KeyPeriod struct {
+	// Record is the ID of the node that introduced the key, which is either
+	// the Identity or one of its KeyRotations
+	Record *fields.QualifiedHash
+	Key    *fields.QualifiedKey
+	// Start is the creation time of Record. Nodes created at or after Start
+	// and before the Start of the following period are signed by Key. The
+	// first period has no start, so that it covers nodes without a creation
+	// time.
+	Start fields.Timestamp
+	// Revoked is whether the key has been revoked by a KeyRevocation
+	Revoked bool
+}
+
+// KeyHistory is the sequence of keys used by an Identity, oldest first.
+type KeyHistory struct {
+	Identity *Identity
+	Periods  []KeyPeriod
+}
+
+// LoadKeyHistory follows the KeyRotations of the identity within the store,
+// starting from the key of the Identity itself, and marks the keys revoked by
+// its KeyRevocations. If more than one KeyRotation replaces the same key, the
+// key may have been rotated by someone else who holds it, and their creation
+// times are chosen by whoever signed them, so none of them is followed and
+// the history ends with that key. Signatures are not checked, so the store
+// should only contain validated nodes (see ValidatingStore).
+func LoadKeyHistory(store QueryStore, identity *Identity) (*KeyHistory, error) {
+	page, err := store.Query(&Query{Target: identity.ID()})
+	if err != nil {
+		return nil, err
+	}
+	// the rotations of each key record and the revoked key records, both by
+	// the text form of the record's ID
+	rotations := make(map[string][]*KeyRotation)
+	revoked := make(map[string]bool)
+	for _, node := range page.Nodes {
+		switch n := node.(type) {
+		case *KeyRotation:
+			if !n.Author.Equals(identity.ID()) {
+				continue
+			}
+			previous, err := n.Previous.MarshalString()
+			if err != nil {
+				return nil, err
+			}
+			rotations[previous] = append(rotations[previous], n)
+		case *KeyRevocation:
+			if !n.Author.Equals(identity.ID()) {
+				continue
+			}
+			record, err := n.Revoked.MarshalString()
+			if err != nil {
+				return nil, err
+			}
+			revoked[record] = true
+		}
+	}
+	history := &KeyHistory{Identity: identity}
+	record, key, start := identity.ID(), &identity.PublicKey, fields.Timestamp(0)
+	for {
+		id, err := record.MarshalString()
+		if err != nil {
+			return nil, err
+		}
+		history.Periods = append(history.Periods, KeyPeriod{
+			Record:  record,
+			Key:     key,
+			Start:   start,
+			Revoked: revoked[id],
+		})
+		if len(rotations[id]) != 1 {
+			break
+		}
+		next := rotations[id][0]
+		record, key, start = next.ID(), &next.PublicKey, next.Created
+	}
+	return history, nil
+}
+
+// period returns the period introduced by the key record with the given ID.
+func (h *KeyHistory) period(record *fields.QualifiedHash) (*KeyPeriod, bool) {
+	for i := range h.Periods {
+		if h.Periods[i].Record.Equals(record) {
+			return &h.Periods[i], true
+		}
+	}
+	return nil, false
+}
+
+// Current returns the period of the key that the Identity uses now, which is
+// the last one in the history. It returns an error if that key has been
+// revoked.
+func (h *KeyHistory) Current() (*KeyPeriod, error) {
+	current := &h.Periods[len(h.Periods)-1]
+	if current.Revoked {
+		return nil, fmt.Errorf("The current key of %v, from key record %v, has been revoked", h.Identity.ID(), current.Record)
+	}
+	return current, nil
+}
+
+// KeyFor returns the key that should have signed the given node. KeyRotations
+// and KeyRevocations are signed by the key of the record that they name.
+// Anyone who obtained a revoked key could use it to take over the Identity,
+// so KeyFor returns an error for a KeyRotation of a revoked key unless the
+// history already follows that KeyRotation. A KeyRevocation remains valid
+// after the key is revoked. Every other node is signed by the key in use when
+// it was created, and KeyFor returns an error if that key has been revoked.
+func (h *KeyHistory) KeyFor(v SignatureValidator) (*fields.QualifiedKey, error) {
+	if r, ok := v.(keyRecordSigned); ok {
+		period, ok := h.period(r.signingRecord())
+		if !ok {
+			return nil, fmt.Errorf("Key record %v is not part of the key history of %v", r.signingRecord(), h.Identity.ID())
+		}
+		if rotation, ok := v.(*KeyRotation); ok && period.Revoked {
+			if _, followed := h.period(rotation.ID()); !followed {
+				return nil, fmt.Errorf("Key record %v has been revoked, so its key cannot be rotated", period.Record)
+			}
+		}
+		return period.Key, nil
+	}
+	var created fields.Timestamp
+	if c, ok := v.(interface{ common() *commonNode }); ok {
+		created = c.common().Created
+	}
+	period := &h.Periods[0]
+	for i := 1; i < len(h.Periods) && h.Periods[i].Start <= created; i++ {
+		period = &h.Periods[i]
+	}
+	if period.Revoked {
+		return nil, fmt.Errorf("Node was signed by key %v, which has been revoked", period.Record)
+	}
+	return period.Key, nil
+}
+
+// validateSignatureIn validates the signature of a node by the given author.
+// If the store is a QueryStore, the key is chosen from the author's key
+// history. Otherwise, only the key of the Identity itself is considered.
+func validateSignatureIn(store Store, v SignatureValidator, author *Identity) (bool, error) {
+	queryable, ok := store.(QueryStore)
+	if !ok || v.IsIdentity() {
+		return ValidateSignature(v, author)
+	}
+	history, err := LoadKeyHistory(queryable, author)
+	if err != nil {
+		return false, err
+	}
+	return ValidateSignatureWithHistory(v, history)
+}
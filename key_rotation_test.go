@@ -0,0 +1,201 @@
+package forest_test
+
+import (
+	"testing"
+	"time"
+
+	forest "git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// builderAt returns a Builder that creates nodes at the given time.
+func builderAt(identity *forest.Identity, signer forest.Signer, at time.Time) *forest.Builder {
+	builder := forest.As(identity, signer)
+	builder.Now = func() time.Time { return at }
+	return builder
+}
+
+func newKeyRotationOrSkip(t *testing.T, builder *forest.Builder, previous forest.Node, next forest.Signer) *forest.KeyRotation {
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	rotation, err := builder.NewKeyRotation(previous, next, metadata)
+	if err != nil {
+		t.Skip("Failed to create key rotation", err)
+	}
+	return rotation
+}
+
+func newKeyRevocationOrSkip(t *testing.T, builder *forest.Builder, revoked forest.Node) *forest.KeyRevocation {
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	revocation, err := builder.NewKeyRevocation(revoked, metadata)
+	if err != nil {
+		t.Skip("Failed to create key revocation", err)
+	}
+	return revocation
+}
+
+// makeRotatedIdentityOrSkip creates an identity whose key is rotated one
+// minute from now, adding both to a ValidatingStore. It also returns the
+// signers for the original and the new key.
+func makeRotatedIdentityOrSkip(t *testing.T) (*forest.ValidatingStore, *forest.Identity, *forest.KeyRotation, forest.Signer, forest.Signer) {
+	identity, oldSigner := MakeValidIdentityOrSkip(t)
+	newSigner := MakeEd25519SignerOrSkip(t)
+	rotation := newKeyRotationOrSkip(t, builderAt(identity, oldSigner, time.Now().Add(time.Minute)), identity, newSigner)
+	s := forest.NewValidatingStore(forest.NewMemoryStore())
+	for _, node := range []forest.Node{identity, rotation} {
+		if err := s.Add(node); err != nil {
+			t.Fatalf("Store should accept valid node, got %v", err)
+		}
+	}
+	return s, identity, rotation, oldSigner, newSigner
+}
+
+func TestKeyRotationSelectsKeyByCreationTime(t *testing.T) {
+	s, identity, rotation, oldSigner, newSigner := makeRotatedIdentityOrSkip(t)
+	rotated := rotation.Created.Time()
+	name := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte("Test Community"))
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	community, err := builderAt(identity, oldSigner, rotated.Add(-time.Second)).NewCommunity(name, metadata)
+	if err != nil {
+		t.Skip("Failed to create community", err)
+	}
+	if err := s.Add(community); err != nil {
+		t.Errorf("Node signed by the old key before the rotation should be valid, got %v", err)
+	}
+	after := builderAt(identity, newSigner, rotated.Add(time.Second))
+	if err := s.Add(newReplyOrSkip(t, after, community, "new key")); err != nil {
+		t.Errorf("Node signed by the new key after the rotation should be valid, got %v", err)
+	}
+	stale := builderAt(identity, oldSigner, rotated.Add(time.Second))
+	expectValidationFailure(t, s.Add(newReplyOrSkip(t, stale, community, "old key")), forest.CheckSignature)
+	early := builderAt(identity, newSigner, rotated.Add(-time.Second))
+	expectValidationFailure(t, s.Add(newReplyOrSkip(t, early, community, "early")), forest.CheckSignature)
+}
+
+func TestKeyRotationMustBeSignedByPreviousKey(t *testing.T) {
+	s, identity, rotation, _, newSigner := makeRotatedIdentityOrSkip(t)
+	later := builderAt(identity, newSigner, rotation.Created.Time().Add(time.Minute))
+	// the identity's key was replaced, so it can only be rotated again by the new key
+	forged := newKeyRotationOrSkip(t, later, identity, MakeEd25519SignerOrSkip(t))
+	expectValidationFailure(t, s.Add(forged), forest.CheckSignature)
+	if err := s.Add(newKeyRotationOrSkip(t, later, rotation, MakeEd25519SignerOrSkip(t))); err != nil {
+		t.Errorf("Rotation signed by the previous key should be valid, got %v", err)
+	}
+	history, err := forest.LoadKeyHistory(s, identity)
+	if err != nil {
+		t.Fatalf("Failed to load key history: %v", err)
+	}
+	if len(history.Periods) != 3 {
+		t.Errorf("Expected 3 keys in the history, got %d", len(history.Periods))
+	}
+}
+
+func TestKeyRotationRequiresTimestamps(t *testing.T) {
+	identity, signer := MakeValidIdentityOrSkip(t)
+	builder := forest.As(identity, signer)
+	builder.SchemaVersion = fields.VersionInitial
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	if _, err := builder.NewKeyRotation(identity, MakeEd25519SignerOrSkip(t), metadata); err == nil {
+		t.Errorf("Key rotation without a creation time should be invalid")
+	}
+	other, _ := MakeValidIdentityOrSkip(t)
+	if _, err := forest.As(identity, signer).NewKeyRotation(other, MakeEd25519SignerOrSkip(t), metadata); err == nil {
+		t.Errorf("Key rotation of another identity should be invalid")
+	}
+}
+
+func TestKeyRevocationRejectsRevokedKey(t *testing.T) {
+	s, identity, rotation, oldSigner, newSigner := makeRotatedIdentityOrSkip(t)
+	rotated := rotation.Created.Time()
+	name := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte("Test Community"))
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	community, err := builderAt(identity, newSigner, rotated.Add(time.Second)).NewCommunity(name, metadata)
+	if err != nil {
+		t.Skip("Failed to create community", err)
+	}
+	if err := s.Add(community); err != nil {
+		t.Fatalf("Store should accept valid node, got %v", err)
+	}
+	// only the holder of a key can revoke it
+	expectValidationFailure(t, s.Add(newKeyRevocationOrSkip(t, forest.As(identity, newSigner), identity)), forest.CheckSignature)
+	if err := s.Add(newKeyRevocationOrSkip(t, forest.As(identity, oldSigner), identity)); err != nil {
+		t.Fatalf("Store should accept valid revocation, got %v", err)
+	}
+	backdated := builderAt(identity, oldSigner, rotated.Add(-time.Second))
+	expectValidationFailure(t, s.Add(newReplyOrSkip(t, backdated, community, "revoked")), forest.CheckSignature)
+	current := builderAt(identity, newSigner, rotated.Add(2*time.Second))
+	if err := s.Add(newReplyOrSkip(t, current, community, "current")); err != nil {
+		t.Errorf("Node signed by a key that was not revoked should be valid, got %v", err)
+	}
+	history, err := forest.LoadKeyHistory(s, identity)
+	if err != nil {
+		t.Fatalf("Failed to load key history: %v", err)
+	}
+	if !history.Periods[0].Revoked || history.Periods[1].Revoked {
+		t.Errorf("Expected only the original key to be revoked")
+	}
+}
+
+func TestKeyRotationRoundTrip(t *testing.T) {
+	s, identity, rotation, oldSigner, _ := makeRotatedIdentityOrSkip(t)
+	revocation := newKeyRevocationOrSkip(t, forest.As(identity, oldSigner), identity)
+	if err := s.Add(revocation); err != nil {
+		t.Fatalf("Store should accept valid revocation, got %v", err)
+	}
+	assertRoundTrips(t, s, rotation, revocation)
+}
+
+func TestKeyRotationCannotBeHijackedWithRevokedKey(t *testing.T) {
+	s, identity, rotation, oldSigner, newSigner := makeRotatedIdentityOrSkip(t)
+	rotated := rotation.Created.Time()
+	if err := s.Add(newKeyRevocationOrSkip(t, forest.As(identity, oldSigner), identity)); err != nil {
+		t.Fatalf("Store should accept valid revocation, got %v", err)
+	}
+	// someone holding the leaked original key rotates it to their own key,
+	// backdating the rotation to precede the owner's
+	attacker := MakeEd25519SignerOrSkip(t)
+	hijack := newKeyRotationOrSkip(t, builderAt(identity, oldSigner, rotated.Add(-30*time.Second)), identity, attacker)
+	expectValidationFailure(t, s.Add(hijack), forest.CheckSignature)
+
+	name := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte("Test Community"))
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	for _, when := range []time.Time{rotated.Add(-10 * time.Second), rotated.Add(time.Second)} {
+		community, err := builderAt(identity, attacker, when).NewCommunity(name, metadata)
+		if err != nil {
+			t.Skip("Failed to create community", err)
+		}
+		expectValidationFailure(t, s.Add(community), forest.CheckSignature)
+	}
+	community, err := builderAt(identity, newSigner, rotated.Add(time.Second)).NewCommunity(name, metadata)
+	if err != nil {
+		t.Skip("Failed to create community", err)
+	}
+	if err := s.Add(community); err != nil {
+		t.Errorf("Node signed by the owner's new key should be valid, got %v", err)
+	}
+}
+
+func TestConflictingKeyRotationsEndHistory(t *testing.T) {
+	s, identity, rotation, oldSigner, newSigner := makeRotatedIdentityOrSkip(t)
+	rotated := rotation.Created.Time()
+	// the original key has not been revoked, so the conflicting rotation is
+	// accepted, but neither rotation can be trusted
+	conflict := newKeyRotationOrSkip(t, builderAt(identity, oldSigner, rotated.Add(-30*time.Second)), identity, MakeEd25519SignerOrSkip(t))
+	if err := s.Add(conflict); err != nil {
+		t.Fatalf("Store should accept rotation signed by the previous key, got %v", err)
+	}
+	history, err := forest.LoadKeyHistory(s, identity)
+	if err != nil {
+		t.Fatalf("Failed to load key history: %v", err)
+	}
+	if len(history.Periods) != 1 {
+		t.Errorf("Expected conflicting rotations to end the key history, got %d keys", len(history.Periods))
+	}
+	later := builderAt(identity, newSigner, rotated.Add(time.Second))
+	name := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte("Test Community"))
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	community, err := later.NewCommunity(name, metadata)
+	if err != nil {
+		t.Skip("Failed to create community", err)
+	}
+	expectValidationFailure(t, s.Add(community), forest.CheckSignature)
+}
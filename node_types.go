@@ -0,0 +1,88 @@
+package forest
+
+import (
+	"fmt"
+
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// nodeTypeRegistration describes how to create nodes of a particular type.
+type nodeTypeRegistration struct {
+	newNode   func() Node
+	unmarshal func([]byte) (Node, error)
+}
+
+// nodeTypes holds every node type that can be unmarshaled. It is not
+// synchronized, so it may only be modified during program initialization.
+var nodeTypes = map[fields.NodeType]*nodeTypeRegistration{
+	fields.NodeTypeIdentity:      registration(newIdentity, UnmarshalIdentity),
+	fields.NodeTypeCommunity:     registration(newCommunity, UnmarshalCommunity),
+	fields.NodeTypeReply:         registration(newReply, UnmarshalReply),
+	fields.NodeTypeEdit:          registration(newEdit, UnmarshalEdit),
+	fields.NodeTypeRetraction:    registration(newRetraction, UnmarshalRetraction),
+	fields.NodeTypeReaction:      registration(newReaction, UnmarshalReaction),
+	fields.NodeTypeKeyRotation:   registration(newKeyRotation, UnmarshalKeyRotation),
+	fields.NodeTypeKeyRevocation: registration(newKeyRevocation, UnmarshalKeyRevocation),
+	fields.NodeTypeRole:          registration(newRole, UnmarshalRole),
+	fields.NodeTypeProfileUpdate: registration(newProfileUpdate, UnmarshalProfileUpdate),
+	fields.NodeTypeChunk:         registration(newChunk, UnmarshalChunk),
+	fields.NodeTypeAttachment:    registration(newAttachment, UnmarshalAttachment),
+}
+
+// registration adapts the constructor and unmarshal function of one of the
+// node types declared in this package for the table of node types.
+func registration[T Node](newNode func() T, unmarshal func([]byte) (T, error)) *nodeTypeRegistration {
+	return &nodeTypeRegistration{
+		newNode: func() Node { return newNode() },
+		unmarshal: func(b []byte) (Node, error) {
+			node, err := unmarshal(b)
+			if err != nil {
+				// avoid returning a nil pointer within a non-nil Node
+				return nil, err
+			}
+			return node, nil
+		},
+	}
+}
+
+// RegisterNodeType adds a new kind of node to the Forest. The name is used as
+// the text form of the node type (see fields.RegisterNodeType). newNode must
+// return an empty node of the new type, and unmarshal must parse the binary
+// form of a node of the new type.
+//
+// A Decoder finds where a node ends within a stream from the descriptors of
+// its fields when the new type embeds one of the node types declared in this
+// package. Otherwise, it relies on unmarshal, which is given the node followed
+// by whatever else the Decoder has buffered. In that case, unmarshal must
+// return a *TrailingDataError holding the length of the node when b continues
+// beyond the end of the node, and an error wrapping io.ErrUnexpectedEOF when b
+// ends partway through it. If unmarshal ignores trailing data instead, the
+// nodes that follow within the stream are lost.
+//
+// Once registered, nodes of the new type are returned by UnmarshalBinaryNode,
+// and so can be read by a Decoder and loaded by an OrchardStore. In order to
+// be accepted by a ValidatingStore, nodes must also implement Hashable and
+// SignatureValidator. RegisterNodeType should only be called during program
+// initialization, as the table of node types is not synchronized.
+func RegisterNodeType(t fields.NodeType, name string, newNode func() Node, unmarshal func([]byte) (Node, error)) error {
+	if newNode == nil || unmarshal == nil {
+		return fmt.Errorf("Node type %s must have a constructor and an unmarshal function", name)
+	}
+	if _, exists := nodeTypes[t]; exists {
+		return fmt.Errorf("Node type %d is already registered", t)
+	}
+	if err := fields.RegisterNodeType(t, name); err != nil {
+		return err
+	}
+	nodeTypes[t] = &nodeTypeRegistration{newNode: newNode, unmarshal: unmarshal}
+	return nil
+}
+
+// NewNode returns an empty node of the given type.
+func NewNode(t fields.NodeType) (Node, error) {
+	registration, ok := nodeTypes[t]
+	if !ok {
+		return nil, fmt.Errorf("Unknown node type %d", t)
+	}
+	return registration.newNode(), nil
+}
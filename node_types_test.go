@@ -0,0 +1,88 @@
+package forest_test
+
+import (
+	"testing"
+
+	forest "git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// testNodeType is a custom node type with the same fields as a reply
+const testNodeType fields.NodeType = 50
+
+type testNode struct {
+	forest.Reply
+}
+
+func (n *testNode) Equals(other interface{}) bool {
+	other2, valid := other.(*testNode)
+	return valid && n.Reply.Equals(&other2.Reply)
+}
+
+var registerTestNodeErr = forest.RegisterNodeType(testNodeType, "test-note",
+	func() forest.Node { return new(testNode) },
+	func(b []byte) (forest.Node, error) {
+		n := new(testNode)
+		if err := n.UnmarshalBinary(b); err != nil {
+			return nil, err
+		}
+		return n, nil
+	})
+
+// makeTestNodeOrSkip converts a reply into a signed node of the custom type.
+func makeTestNodeOrSkip(t *testing.T, reply *forest.Reply, signer forest.Signer) *testNode {
+	if registerTestNodeErr != nil {
+		t.Fatalf("Failed to register node type: %v", registerTestNodeErr)
+	}
+	reply.Type = testNodeType
+	resigned := resignReplyOrSkip(t, reply, signer)
+	return &testNode{Reply: *resigned}
+}
+
+func TestRegisterNodeTypeRejectsDuplicates(t *testing.T) {
+	newNode := func() forest.Node { return new(testNode) }
+	unmarshal := func(b []byte) (forest.Node, error) { return nil, nil }
+	if err := forest.RegisterNodeType(fields.NodeTypeReply, "another-reply", newNode, unmarshal); err == nil {
+		t.Errorf("Registering an existing node type should fail")
+	}
+	if err := forest.RegisterNodeType(testNodeType+1, "reply", newNode, unmarshal); err == nil {
+		t.Errorf("Registering an existing node type name should fail")
+	}
+	if err := forest.RegisterNodeType(testNodeType+1, "incomplete", nil, nil); err == nil {
+		t.Errorf("Registering a node type without functions should fail")
+	}
+}
+
+func TestUnmarshalRegisteredNodeType(t *testing.T) {
+	identity, signer, community, reply := MakeValidReplyOrSkip(t)
+	node := makeTestNodeOrSkip(t, reply, signer)
+	// custom nodes have no JSON form of their own
+	assertRoundTrips(t, nil, node)
+	if text, err := fields.NodeType(testNodeType).MarshalText(); err != nil || string(text) != "test-note" {
+		t.Errorf("Expected custom node type to have text form %q, got %q", "test-note", text)
+	}
+	if empty, err := forest.NewNode(testNodeType); err != nil {
+		t.Errorf("Failed to construct custom node: %v", err)
+	} else if _, ok := empty.(*testNode); !ok {
+		t.Errorf("Expected constructor to return *testNode, got %T", empty)
+	}
+
+	// stores should accept and reload the custom node
+	orchard, cleanup := makeOrchardStoreOrSkip(t)
+	defer cleanup()
+	s := forest.NewValidatingStore(orchard)
+	for _, n := range []forest.Node{identity, community, node} {
+		if err := s.Add(n); err != nil {
+			t.Errorf("Store should accept valid node, got %v", err)
+		}
+	}
+	reloaded, err := forest.NewOrchardStore(orchard.Dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	if found, has, err := reloaded.Get(node.ID()); err != nil || !has {
+		t.Errorf("Reopened store should contain custom node, err: %v", err)
+	} else if _, ok := found.(*testNode); !ok {
+		t.Errorf("Expected reopened store to return *testNode, got %T", found)
+	}
+}
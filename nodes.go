@@ -2,13 +2,19 @@ package forest
 
 import (
 	"bytes"
+	"encoding"
 	"fmt"
+	"time"
 
 	"git.sr.ht/~whereswaldon/forest-go/fields"
 )
 
 const MaxNameLength = 256
 
+// MaxTimestampSkew is how much older than its parent a node may claim to be.
+// It allows for clocks that disagree between the authors of the nodes.
+const MaxTimestampSkew = 5 * time.Minute
+
 type Validator interface {
 	Validate() error
 }
@@ -19,6 +25,7 @@ type Node interface {
 	Equals(interface{}) bool
 	ValidateShallow() error
 	ValidateDeep(Store) error
+	encoding.BinaryMarshaler
 }
 
 // NodeTypeOf returns the NodeType of the provided binary-marshaled node.
@@ -34,8 +41,8 @@ func VersionAndNodeTypeOf(b []byte) (fields.Version, fields.NodeType, error) {
 		ver fields.Version
 		t   fields.NodeType
 		// this array defines the serialization order of the first two fields of
-		// any node. If this order ever changes, it must be updated here and in
-		// commonNode.presignSerializationOrder
+		// any node. Every schema must begin with these fields so that they can
+		// be read before the version of the node is known
 		order = []fields.BidirectionalBinaryMarshaler{
 			&ver,
 			&t,
@@ -48,24 +55,20 @@ func VersionAndNodeTypeOf(b []byte) (fields.Version, fields.NodeType, error) {
 // UnmarshalBinaryNode unmarshals a node of any type. If it does not return an
 // error, the concrete type of the first return parameter will be one of the
 // node structs declared in this package (e.g. Identity, Community, etc...)
+// or a type added with RegisterNodeType.
 func UnmarshalBinaryNode(b []byte) (Node, error) {
 	v, t, err := VersionAndNodeTypeOf(b)
 	if err != nil {
 		return nil, err
 	}
-	if v > fields.CurrentVersion {
-		return nil, fmt.Errorf("Unable to unmarshal node of version %d, only supports <= %d", v, fields.CurrentVersion)
-	}
-	switch t {
-	case fields.NodeTypeIdentity:
-		return UnmarshalIdentity(b)
-	case fields.NodeTypeCommunity:
-		return UnmarshalCommunity(b)
-	case fields.NodeTypeReply:
-		return UnmarshalReply(b)
-	default:
+	if err := validateVersion(v); err != nil {
+		return nil, fmt.Errorf("Unable to unmarshal node: %v", err)
+	}
+	registration, ok := nodeTypes[t]
+	if !ok {
 		return nil, fmt.Errorf("Unable to unmarshal node of type %d, unknown type", t)
 	}
+	return registration.unmarshal(b)
 }
 
 // generic node
@@ -79,6 +82,9 @@ type commonNode struct {
 	Depth              fields.TreeDepth
 	Metadata           fields.QualifiedContent
 	Author fields.QualifiedHash
+	// Created is the time at which the node was created. It is only present
+	// in nodes of schema version fields.VersionTimestamps and later.
+	Created            fields.Timestamp
 	Signature          fields.QualifiedSignature
 }
 
@@ -91,20 +97,28 @@ func (n commonNode) ID() *fields.QualifiedHash {
 }
 
 func (n commonNode) ParentID() *fields.QualifiedHash {
-	return &fields.QualifiedHash{n.Parent.Descriptor, n.Parent.Blob}
+	return &fields.QualifiedHash{Descriptor: n.Parent.Descriptor, Blob: n.Parent.Blob}
 }
 
+// presignSerializationOrder returns the common fields that precede the
+// node-specific fields in the node's schema version.
 func (n *commonNode) presignSerializationOrder() []fields.BidirectionalBinaryMarshaler {
-	order := []fields.BidirectionalBinaryMarshaler{
-		&n.SchemaVersion,
-		&n.Type,
-	}
-	order = append(order, &n.Parent)
-	order = append(order, n.IDDesc.SerializationOrder()...)
-	order = append(order, &n.Depth)
-	order = append(order, &n.Metadata)
-	order = append(order, &n.Author)
-	return order
+	s, err := schemaFor(n.SchemaVersion)
+	if err != nil {
+		// nodes of unsupported versions cannot be unmarshaled and never pass
+		// validation, so fall back to the fields present in every version
+		return presignSerializationOrderV1(n)
+	}
+	return s.presignSerializationOrder(n)
+}
+
+// unmarshalBinaryVersion reads the schema version from the beginning of the
+// binary form of the node, as the version determines which fields follow.
+func (n *commonNode) unmarshalBinaryVersion(b []byte) error {
+	if _, err := fields.UnmarshalAll(b, &n.SchemaVersion); err != nil {
+		return err
+	}
+	return validateVersion(n.SchemaVersion)
 }
 
 func (n *commonNode) postsignSerializationOrder() []fields.BidirectionalBinaryMarshaler {
@@ -134,6 +148,11 @@ func (n *commonNode) SignatureIdentityHash() *fields.QualifiedHash {
 	return &n.Author
 }
 
+// common provides access to the fields shared by every node type.
+func (n *commonNode) common() *commonNode {
+	return n
+}
+
 func (n commonNode) IsIdentity() bool {
 	return n.Type == fields.NodeTypeIdentity
 }
@@ -150,6 +169,7 @@ func (n *commonNode) Equals(n2 *commonNode) bool {
 		n.Depth.Equals(&n2.Depth) &&
 		n.Metadata.Equals(&n2.Metadata) &&
 		n.Author.Equals(&n2.Author) &&
+		n.Created.Equals(&n2.Created) &&
 		n.Signature.Equals(&n2.Signature)
 }
 
@@ -159,8 +179,9 @@ func (n *commonNode) ValidateShallow() error {
 	if _, validType := fields.ValidNodeTypes[n.Type]; !validType {
 		return fmt.Errorf("%d is not a valid node type", n.Type)
 	}
-	if n.SchemaVersion > fields.CurrentVersion {
-		return fmt.Errorf("%d is higher than than the supported version %d", n.SchemaVersion, fields.CurrentVersion)
+	schema, err := schemaFor(n.SchemaVersion)
+	if err != nil {
+		return err
 	}
 	id := n.ID()
 	needsValidation := []Validator{id, &n.Parent, &n.Metadata, &n.Author, &n.Signature}
@@ -172,18 +193,22 @@ func (n *commonNode) ValidateShallow() error {
 	if n.Metadata.Descriptor.Type != fields.ContentTypeJSON {
 		return fmt.Errorf("Metadata must be JSON, got content type %d", n.Metadata.Descriptor.Type)
 	}
-	return nil
+	return schema.validateShallow(n)
 }
 
 // ValidateDeep checks for the existence of all referenced nodes within the provided store.
 func (n *commonNode) ValidateDeep(store Store) error {
 	// ensure known parent
 	if !n.Parent.Equals(fields.NullHash()) {
-		if _, has, err := store.Get(&n.Parent); !has {
+		parent, has, err := store.Get(&n.Parent)
+		if !has {
 			return fmt.Errorf("Unknown parent %v", n.Parent)
 		} else if err != nil {
 			return err
 		}
+		if err := n.validateCreatedAfter(parent); err != nil {
+			return err
+		}
 	}
 	// ensure known author
 	if !n.Author.Equals(fields.NullHash()) {
@@ -196,6 +221,26 @@ func (n *commonNode) ValidateDeep(store Store) error {
 	return nil
 }
 
+// validateCreatedAfter ensures that the node was not created before its parent,
+// allowing for up to MaxTimestampSkew of disagreement between clocks. It only
+// applies when both nodes record their creation time.
+func (n *commonNode) validateCreatedAfter(parent Node) error {
+	p, ok := parent.(interface{ common() *commonNode })
+	if !ok {
+		return nil
+	}
+	parentNode := p.common()
+	for _, version := range []fields.Version{n.SchemaVersion, parentNode.SchemaVersion} {
+		if s, err := schemaFor(version); err != nil || !s.timestamps {
+			return nil
+		}
+	}
+	if n.Created.Time().Add(MaxTimestampSkew).Before(parentNode.Created.Time()) {
+		return fmt.Errorf("Node created at %v is older than its parent, created at %v", n.Created.Time(), parentNode.Created.Time())
+	}
+	return nil
+}
+
 // concrete nodes
 
 // Identity nodes represent a user. They associate a username with a public key that the user
@@ -257,8 +302,10 @@ func UnmarshalIdentity(b []byte) (*Identity, error) {
 }
 
 func (i *Identity) UnmarshalBinary(b []byte) error {
-	_, err := fields.UnmarshalAll(b, fields.AsUnmarshaler(i.SerializationOrder())...)
-	if err != nil {
+	if err := i.unmarshalBinaryVersion(b); err != nil {
+		return err
+	}
+	if err := UnmarshalBinary(i, b); err != nil {
 		return err
 	}
 	idBytes, err := computeID(i)
@@ -365,8 +412,10 @@ func UnmarshalCommunity(b []byte) (*Community, error) {
 }
 
 func (c *Community) UnmarshalBinary(b []byte) error {
-	_, err := fields.UnmarshalAll(b, fields.AsUnmarshaler(c.SerializationOrder())...)
-	if err != nil {
+	if err := c.unmarshalBinaryVersion(b); err != nil {
+		return err
+	}
+	if err := UnmarshalBinary(c, b); err != nil {
 		return err
 	}
 	idBytes, err := computeID(c)
@@ -479,8 +528,10 @@ func UnmarshalReply(b []byte) (*Reply, error) {
 }
 
 func (r *Reply) UnmarshalBinary(b []byte) error {
-	_, err := fields.UnmarshalAll(b, fields.AsUnmarshaler(r.SerializationOrder())...)
-	if err != nil {
+	if err := r.unmarshalBinaryVersion(b); err != nil {
+		return err
+	}
+	if err := UnmarshalBinary(r, b); err != nil {
 		return err
 	}
 	idBytes, err := computeID(r)
@@ -537,12 +588,17 @@ func (r *Reply) ValidateDeep(store Store) error {
 	if r.Depth > fields.TreeDepth(1) {
 		needed = append(needed, &r.ConversationID)
 	}
+	var parent Node
 	for _, neededNode := range needed {
-		if _, has, err := store.Get(neededNode); !has {
+		node, has, err := store.Get(neededNode)
+		if !has {
 			return fmt.Errorf("Missing required node %v", neededNode)
 		} else if err != nil {
 			return err
 		}
+		if neededNode == &r.Parent {
+			parent = node
+		}
 	}
-	return nil
+	return r.validateCreatedAfter(parent)
 }
@@ -0,0 +1,222 @@
+package forest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// orchardTempPrefix is prepended to the names of files that are being
+// written into an OrchardStore. Files with this prefix are never considered
+// part of the store's contents.
+const orchardTempPrefix = ".tmp-"
+
+// OrchardStore is a Store that persists nodes within a directory on disk.
+// Each node is stored in its binary form within a file named by the text
+// form of its ID. Nodes are written into a temporary file and then renamed
+// into place, so a crash during a write will never leave a partial node
+// within the store. An OrchardStore must be created with NewOrchardStore.
+//
+// An OrchardStore is safe for concurrent use by multiple goroutines within a
+// single process. Nodes added during a call to Iterate or CopyInto may or may
+// not be visited by it. The directory must not be shared between processes.
+type OrchardStore struct {
+	Dir   string
+	index *nodeIndex
+	mutex sync.RWMutex
+}
+
+// NewOrchardStore creates an OrchardStore that persists nodes within dir.
+// The directory will be created if it does not exist, and any temporary
+// files left behind by an interrupted write will be removed. Every node
+// already in the directory is read in order to build the store's index.
+func NewOrchardStore(dir string) (*OrchardStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), orchardTempPrefix) {
+			if err := os.Remove(filepath.Join(dir, info.Name())); err != nil {
+				return nil, err
+			}
+		}
+	}
+	o := &OrchardStore{Dir: dir, index: newNodeIndex()}
+	ids, err := o.ids()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		node, has, err := o.GetID(id)
+		if err != nil {
+			return nil, fmt.Errorf("Failed loading node %s: %v", id, err)
+		} else if !has {
+			continue
+		}
+		if err := o.index.add(node); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// ids returns the text form of the IDs of every node within the store.
+func (o *OrchardStore) ids() ([]string, error) {
+	infos, err := ioutil.ReadDir(o.Dir)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() || strings.HasPrefix(info.Name(), orchardTempPrefix) {
+			continue
+		}
+		ids = append(ids, info.Name())
+	}
+	return ids, nil
+}
+
+func (o *OrchardStore) Size() (int, error) {
+	ids, err := o.ids()
+	if err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+func (o *OrchardStore) CopyInto(other Store) error {
+	return o.Iterate(other.Add)
+}
+
+// Iterate invokes fn once for each node within the store. Each node is read
+// from disk only when it is visited.
+func (o *OrchardStore) Iterate(fn func(Node) error) error {
+	ids, err := o.ids()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		node, has, err := o.GetID(id)
+		if err != nil {
+			return err
+		} else if !has {
+			continue
+		}
+		if err := fn(node); err == ErrStopIteration {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *OrchardStore) Get(id *fields.QualifiedHash) (Node, bool, error) {
+	idString, err := id.MarshalString()
+	if err != nil {
+		return nil, false, err
+	}
+	return o.GetID(idString)
+}
+
+// GetID returns the node with the given text-form ID, if it is present in the store.
+// It returns an error if id is not the text form of a fields.QualifiedHash.
+func (o *OrchardStore) GetID(id string) (Node, bool, error) {
+	// only the canonical text form of a hash is used as a file name, so that
+	// the ID cannot name a file outside of the store
+	hash, err := fields.ParseQualifiedHash(id)
+	if err != nil {
+		return nil, false, err
+	}
+	name, err := hash.MarshalString()
+	if err != nil {
+		return nil, false, err
+	}
+	b, err := ioutil.ReadFile(filepath.Join(o.Dir, name))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	node, err := UnmarshalBinaryNode(b)
+	if err != nil {
+		return nil, false, err
+	}
+	return node, true, nil
+}
+
+// Add writes the node into the store. Adding a node that is already present
+// is a no-op.
+func (o *OrchardStore) Add(node Node) error {
+	id, err := node.ID().MarshalString()
+	if err != nil {
+		return err
+	}
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	path := filepath.Join(o.Dir, id)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	b, err := node.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if err := o.writeAtomically(path, b); err != nil {
+		return err
+	}
+	return o.index.add(node)
+}
+
+// Children returns the IDs of all known children of the node with the given ID.
+func (o *OrchardStore) Children(id *fields.QualifiedHash) ([]*fields.QualifiedHash, error) {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	return o.index.Children(id)
+}
+
+// Query returns a single page of the nodes matching the query.
+func (o *OrchardStore) Query(q *Query) (*QueryPage, error) {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	return o.index.queryStore(q, o.GetID)
+}
+
+// writeAtomically ensures that either all of b is present at path or that
+// no file is created at all.
+func (o *OrchardStore) writeAtomically(path string, b []byte) error {
+	tmp, err := ioutil.TempFile(o.Dir, orchardTempPrefix)
+	if err != nil {
+		return err
+	}
+	cleanup := func(err error) error {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		return cleanup(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return cleanup(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return cleanup(err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
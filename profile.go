@@ -0,0 +1,262 @@
+package forest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// ProfileUpdate nodes replace the name and metadata of an Identity or a
+// Community. An Identity can only be updated by itself, and a Community only
+// by its author. The newest ProfileUpdate of a node wins, so ProfileUpdates
+// must be written with a schema version that records creation times. Like an
+// Edit, a ProfileUpdate has no parent and a depth of zero. Its own Metadata
+// describes the update, while TargetMetadata replaces the Metadata of the
+// target. Use ResolveProfile to find the current name and metadata of a node.
+type ProfileUpdate struct {
+	commonNode
+	Target         fields.QualifiedHash
+	Name           fields.QualifiedContent
+	TargetMetadata fields.QualifiedContent
+}
+
+func newProfileUpdate() *ProfileUpdate {
+	p := new(ProfileUpdate)
+	// define how to serialize this node type's fields
+	return p
+}
+
+// TargetID returns the ID of the Identity or Community updated by this node.
+func (p *ProfileUpdate) TargetID() *fields.QualifiedHash {
+	return &p.Target
+}
+
+func (p *ProfileUpdate) nodeSpecificSerializationOrder() []fields.BidirectionalBinaryMarshaler {
+	return []fields.BidirectionalBinaryMarshaler{&p.Target, &p.Name, &p.TargetMetadata}
+}
+
+func (p *ProfileUpdate) SerializationOrder() []fields.BidirectionalBinaryMarshaler {
+	order := p.commonNode.presignSerializationOrder()
+	order = append(order, p.nodeSpecificSerializationOrder()...)
+	order = append(order, p.commonNode.postsignSerializationOrder()...)
+	return order
+}
+
+func (p ProfileUpdate) MarshalSignedData() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(p.presignSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(p.nodeSpecificSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (p ProfileUpdate) MarshalBinary() ([]byte, error) {
+	signed, err := p.MarshalSignedData()
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(signed)
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(p.postsignSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func UnmarshalProfileUpdate(b []byte) (*ProfileUpdate, error) {
+	p := newProfileUpdate()
+	if err := p.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *ProfileUpdate) UnmarshalBinary(b []byte) error {
+	if err := p.unmarshalBinaryVersion(b); err != nil {
+		return err
+	}
+	if err := UnmarshalBinary(p, b); err != nil {
+		return err
+	}
+	idBytes, err := computeID(p)
+	if err != nil {
+		return err
+	}
+	p.id = fields.Blob(idBytes)
+	return nil
+}
+
+func (p *ProfileUpdate) Equals(other interface{}) bool {
+	p2, valid := other.(*ProfileUpdate)
+	if !valid {
+		return false
+	}
+	return p.commonNode.Equals(&p2.commonNode) &&
+		p.Target.Equals(&p2.Target) &&
+		p.Name.Equals(&p2.Name) &&
+		p.TargetMetadata.Equals(&p2.TargetMetadata)
+}
+
+// ValidateShallow checks all fields for internal validity. It does not check
+// the existence or validity of nodes referenced from this node.
+func (p *ProfileUpdate) ValidateShallow() error {
+	if err := p.commonNode.ValidateShallow(); err != nil {
+		return err
+	}
+	needsValidation := []Validator{&p.Target, &p.Name, &p.TargetMetadata}
+	for _, nv := range needsValidation {
+		if err := nv.Validate(); err != nil {
+			return err
+		}
+	}
+	if p.Name.Descriptor.Length > MaxNameLength {
+		return fmt.Errorf("Name is longer than maximum of %d", MaxNameLength)
+	}
+	if p.TargetMetadata.Descriptor.Type != fields.ContentTypeJSON {
+		return fmt.Errorf("Target metadata must be JSON, got content type %d", p.TargetMetadata.Descriptor.Type)
+	}
+	if s, err := schemaFor(p.SchemaVersion); err != nil || !s.timestamps {
+		return fmt.Errorf("Profile updates must record their creation time, which schema version %d does not", p.SchemaVersion)
+	}
+	if !p.Parent.Equals(fields.NullHash()) {
+		return fmt.Errorf("Profile update parent must be null hash, got %v", p.Parent)
+	}
+	if p.Depth != 0 {
+		return fmt.Errorf("Profile update depth must be 0, got %d", p.Depth)
+	}
+	if p.Target.Equals(fields.NullHash()) {
+		return fmt.Errorf("Profile update target must not be null hash")
+	}
+	if p.Author.Equals(fields.NullHash()) {
+		return fmt.Errorf("Profile update author must not be null hash")
+	}
+	return nil
+}
+
+// ValidateDeep checks that the updated node exists within the store, is owned
+// by the author of the ProfileUpdate, and was not created after it.
+func (p *ProfileUpdate) ValidateDeep(store Store) error {
+	if _, has, err := store.Get(&p.Author); !has {
+		return fmt.Errorf("Missing required node %v", p.Author)
+	} else if err != nil {
+		return err
+	}
+	target, has, err := store.Get(&p.Target)
+	if !has {
+		return fmt.Errorf("Missing required node %v", p.Target)
+	} else if err != nil {
+		return err
+	}
+	owner, err := profileOwner(target)
+	if err != nil {
+		return err
+	}
+	if !owner.Equals(&p.Author) {
+		return fmt.Errorf("Profile update author %v does not own the target", p.Author)
+	}
+	return p.validateCreatedAfter(target)
+}
+
+// profileOwner returns the ID of the Identity that may update the profile of
+// the node, which must be an Identity or a Community.
+func profileOwner(node Node) (*fields.QualifiedHash, error) {
+	switch n := node.(type) {
+	case *Identity:
+		return n.ID(), nil
+	case *Community:
+		return &n.Author, nil
+	}
+	return nil, fmt.Errorf("Profile update target must be an identity or a community, got %T", node)
+}
+
+type profileUpdateJSON struct {
+	nodeJSON
+	Target         *fields.QualifiedHash    `json:"target"`
+	Name           *fields.QualifiedContent `json:"name"`
+	TargetMetadata *fields.QualifiedContent `json:"targetMetadata"`
+}
+
+// MarshalJSON converts the ProfileUpdate into its canonical JSON form.
+func (p *ProfileUpdate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(profileUpdateJSON{
+		nodeJSON:       p.commonNode.toJSON(),
+		Target:         &p.Target,
+		Name:           &p.Name,
+		TargetMetadata: &p.TargetMetadata,
+	})
+}
+
+// UnmarshalJSON populates the ProfileUpdate from its canonical JSON form. It
+// fails unless the ID within the JSON matches the ProfileUpdate's contents.
+// Its signature cannot be checked without its author, so use
+// UnmarshalJSONNode to decode untrusted nodes.
+func (p *ProfileUpdate) UnmarshalJSON(b []byte) error {
+	var in profileUpdateJSON
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	if err := requireJSONFields([]jsonField{
+		{"target", in.Target != nil},
+		{"name", in.Name != nil},
+		{"targetMetadata", in.TargetMetadata != nil},
+	}); err != nil {
+		return err
+	}
+	if err := p.commonNode.fromJSON(&in.nodeJSON, fields.NodeTypeProfileUpdate); err != nil {
+		return err
+	}
+	p.Target = *in.Target
+	p.Name = *in.Name
+	p.TargetMetadata = *in.TargetMetadata
+	return finishJSON(p, &p.commonNode, in.ID)
+}
+
+// Profile is the current name and metadata of an Identity or a Community.
+type Profile struct {
+	Name     *fields.QualifiedContent
+	Metadata *fields.QualifiedContent
+	// Update is the ProfileUpdate that supplied the name and metadata, or
+	// nil if the node has not been updated.
+	Update *ProfileUpdate
+}
+
+// ResolveProfile returns the current profile of the Identity or Community.
+// That is the name and metadata of the newest ProfileUpdate of the node
+// written by its owner, or the node's own name and metadata if it has not
+// been updated. Updates are ordered by their creation time and then by the
+// text form of their IDs. Signatures are not checked, so the store should
+// only contain validated nodes (see ValidatingStore).
+func ResolveProfile(store QueryStore, node Node) (*Profile, error) {
+	owner, err := profileOwner(node)
+	if err != nil {
+		return nil, err
+	}
+	page, err := store.Query(&Query{Target: node.ID()})
+	if err != nil {
+		return nil, err
+	}
+	var latest *ProfileUpdate
+	for _, candidate := range page.Nodes {
+		update, ok := candidate.(*ProfileUpdate)
+		if !ok || !update.Author.Equals(owner) {
+			continue
+		}
+		if latest == nil || isNewer(&update.commonNode, &latest.commonNode) {
+			latest = update
+		}
+	}
+	if latest != nil {
+		return &Profile{Name: &latest.Name, Metadata: &latest.TargetMetadata, Update: latest}, nil
+	}
+	switch n := node.(type) {
+	case *Identity:
+		return &Profile{Name: &n.Name, Metadata: &n.Metadata}, nil
+	case *Community:
+		return &Profile{Name: &n.Name, Metadata: &n.Metadata}, nil
+	}
+	return nil, fmt.Errorf("Cannot resolve the profile of %T", node)
+}
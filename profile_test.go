@@ -0,0 +1,116 @@
+package forest_test
+
+import (
+	"testing"
+	"time"
+
+	forest "git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+func newProfileUpdateOrSkip(t *testing.T, builder *forest.Builder, target forest.Node, name string) *forest.ProfileUpdate {
+	qName := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte(name))
+	targetMetadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte(`{"name":"`+name+`"}`))
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	update, err := builder.NewProfileUpdate(target, qName, targetMetadata, metadata)
+	if err != nil {
+		t.Skip("Failed to create profile update", err)
+	}
+	return update
+}
+
+func expectProfileName(t *testing.T, s forest.QueryStore, node forest.Node, name string, update *forest.ProfileUpdate) {
+	profile, err := forest.ResolveProfile(s, node)
+	if err != nil {
+		t.Fatalf("Failed to resolve profile: %v", err)
+	}
+	if string(profile.Name.Blob) != name {
+		t.Errorf("Expected profile name %q, got %q", name, profile.Name.Blob)
+	}
+	if update == nil && profile.Update != nil {
+		t.Errorf("Expected profile without an update, got %v", profile.Update.ID())
+	} else if update != nil && (profile.Update == nil || !profile.Update.Equals(update)) {
+		t.Errorf("Expected profile from update %v", update.ID())
+	} else if update != nil && !profile.Metadata.Equals(&update.TargetMetadata) {
+		t.Errorf("Expected profile metadata from update %v", update.ID())
+	}
+}
+
+func TestResolveProfile(t *testing.T) {
+	identity, signer, community, _ := MakeValidReplyOrSkip(t)
+	s := forest.NewValidatingStore(forest.NewMemoryStore())
+	for _, node := range []forest.Node{identity, community} {
+		if err := s.Add(node); err != nil {
+			t.Skip("Failed to add node", err)
+		}
+	}
+	expectProfileName(t, s, identity, "Test Name", nil)
+	expectProfileName(t, s, community, "Test Community", nil)
+
+	now := time.Now()
+	first := newProfileUpdateOrSkip(t, builderAt(identity, signer, now.Add(time.Second)), identity, "First")
+	second := newProfileUpdateOrSkip(t, builderAt(identity, signer, now.Add(2*time.Second)), identity, "Second")
+	renamed := newProfileUpdateOrSkip(t, forest.As(identity, signer), community, "Renamed")
+	// add the newer update first to ensure that creation time decides
+	for _, node := range []forest.Node{second, first, renamed} {
+		if err := s.Add(node); err != nil {
+			t.Fatalf("Store should accept valid update, got %v", err)
+		}
+	}
+	expectProfileName(t, s, identity, "Second", second)
+	expectProfileName(t, s, community, "Renamed", renamed)
+}
+
+func TestProfileUpdateRequiresOwner(t *testing.T) {
+	identity, _, community, reply := MakeValidReplyOrSkip(t)
+	other, otherSigner := MakeValidIdentityOrSkip(t)
+	s := forest.NewValidatingStore(forest.NewMemoryStore())
+	for _, node := range []forest.Node{identity, other, community} {
+		if err := s.Add(node); err != nil {
+			t.Skip("Failed to add node", err)
+		}
+	}
+	name := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte("Stolen"))
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	for _, target := range []forest.Node{identity, community, reply} {
+		if _, err := forest.As(other, otherSigner).NewProfileUpdate(target, name, metadata, metadata); err == nil {
+			t.Errorf("Builder should refuse to update the profile of %T owned by another identity", target)
+		}
+	}
+	// disguise the community as belonging to the other identity so that the
+	// builder creates the update, then ensure that the store rejects it
+	disguised := *community
+	disguised.Author = *other.ID()
+	update := newProfileUpdateOrSkip(t, forest.As(other, otherSigner), &disguised, "Stolen")
+	expectValidationFailure(t, s.Add(update), forest.CheckReferences)
+	expectProfileName(t, s, community, "Test Community", nil)
+}
+
+func TestProfileUpdateRoundTrip(t *testing.T) {
+	identity, signer := MakeValidIdentityOrSkip(t)
+	s := forest.NewValidatingStore(forest.NewMemoryStore())
+	update := newProfileUpdateOrSkip(t, forest.As(identity, signer), identity, "Renamed")
+	for _, node := range []forest.Node{identity, update} {
+		if err := s.Add(node); err != nil {
+			t.Fatalf("Store should accept valid node, got %v", err)
+		}
+	}
+	assertRoundTrips(t, s, update)
+}
+
+func TestProfileUpdateRequiresTimestamps(t *testing.T) {
+	identity, signer := MakeValidIdentityOrSkip(t)
+	builder := forest.As(identity, signer)
+	builder.SchemaVersion = fields.VersionInitial
+	name := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte("Renamed"))
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	if _, err := builder.NewProfileUpdate(identity, name, metadata, metadata); err == nil {
+		t.Errorf("Profile update without a creation time should be invalid")
+	}
+	s := forest.NewValidatingStore(forest.NewMemoryStore())
+	if err := s.Add(identity); err != nil {
+		t.Skip("Failed to add identity", err)
+	}
+	stale := newProfileUpdateOrSkip(t, builderAt(identity, signer, identity.Created.Time().Add(-time.Hour)), identity, "Stale")
+	expectValidationFailure(t, s.Add(stale), forest.CheckReferences)
+}
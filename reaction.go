@@ -0,0 +1,244 @@
+package forest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// MaxReactionLength is the maximum length in bytes of the content of a Reaction
+const MaxReactionLength = 64
+
+// Reaction nodes respond to a Reply with short UTF-8 content, like an emoji
+// or a vote, without adding a Reply to the conversation. Like an Edit, a
+// Reaction has no parent and a depth of zero. Use TallyReactions to count the
+// reactions to a Reply.
+type Reaction struct {
+	commonNode
+	Target  fields.QualifiedHash
+	Content fields.QualifiedContent
+}
+
+func newReaction() *Reaction {
+	r := new(Reaction)
+	// define how to serialize this node type's fields
+	return r
+}
+
+// TargetID returns the ID of the Reply that this node reacts to.
+func (r *Reaction) TargetID() *fields.QualifiedHash {
+	return &r.Target
+}
+
+func (r *Reaction) nodeSpecificSerializationOrder() []fields.BidirectionalBinaryMarshaler {
+	return []fields.BidirectionalBinaryMarshaler{&r.Target, &r.Content}
+}
+
+func (r *Reaction) SerializationOrder() []fields.BidirectionalBinaryMarshaler {
+	order := r.commonNode.presignSerializationOrder()
+	order = append(order, r.nodeSpecificSerializationOrder()...)
+	order = append(order, r.commonNode.postsignSerializationOrder()...)
+	return order
+}
+
+func (r Reaction) MarshalSignedData() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(r.presignSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(r.nodeSpecificSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (r Reaction) MarshalBinary() ([]byte, error) {
+	signed, err := r.MarshalSignedData()
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(signed)
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(r.postsignSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func UnmarshalReaction(b []byte) (*Reaction, error) {
+	r := newReaction()
+	if err := r.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reaction) UnmarshalBinary(b []byte) error {
+	if err := r.unmarshalBinaryVersion(b); err != nil {
+		return err
+	}
+	if err := UnmarshalBinary(r, b); err != nil {
+		return err
+	}
+	idBytes, err := computeID(r)
+	if err != nil {
+		return err
+	}
+	r.id = fields.Blob(idBytes)
+	return nil
+}
+
+func (r *Reaction) Equals(other interface{}) bool {
+	r2, valid := other.(*Reaction)
+	if !valid {
+		return false
+	}
+	return r.commonNode.Equals(&r2.commonNode) &&
+		r.Target.Equals(&r2.Target) &&
+		r.Content.Equals(&r2.Content)
+}
+
+// validateContent ensures that the content of the reaction is short UTF-8 text.
+func (r *Reaction) validateContent() error {
+	if err := r.Content.Validate(); err != nil {
+		return err
+	}
+	if r.Content.Descriptor.Type != fields.ContentTypeUTF8String {
+		return fmt.Errorf("Reaction content must be UTF-8, got content type %d", r.Content.Descriptor.Type)
+	}
+	if !utf8.Valid(r.Content.Blob) {
+		return fmt.Errorf("Reaction content is not valid UTF-8")
+	}
+	if len(r.Content.Blob) == 0 {
+		return fmt.Errorf("Reaction content must not be empty")
+	}
+	if len(r.Content.Blob) > MaxReactionLength {
+		return fmt.Errorf("Reaction content is %d bytes, max is %d", len(r.Content.Blob), MaxReactionLength)
+	}
+	return nil
+}
+
+// ValidateShallow checks all fields for internal validity. It does not check
+// the existence or validity of nodes referenced from this node.
+func (r *Reaction) ValidateShallow() error {
+	if err := r.commonNode.ValidateShallow(); err != nil {
+		return err
+	}
+	if err := r.Target.Validate(); err != nil {
+		return err
+	}
+	if err := r.validateContent(); err != nil {
+		return err
+	}
+	if !r.Parent.Equals(fields.NullHash()) {
+		return fmt.Errorf("Reaction parent must be null hash, got %v", r.Parent)
+	}
+	if r.Depth != 0 {
+		return fmt.Errorf("Reaction depth must be 0, got %d", r.Depth)
+	}
+	if r.Target.Equals(fields.NullHash()) {
+		return fmt.Errorf("Reaction target must not be null hash")
+	}
+	if r.Author.Equals(fields.NullHash()) {
+		return fmt.Errorf("Reaction author must not be null hash")
+	}
+	return nil
+}
+
+// ValidateDeep checks that the author and the Reply that the node reacts to
+// exist within the store.
+func (r *Reaction) ValidateDeep(store Store) error {
+	if _, has, err := store.Get(&r.Author); !has {
+		return fmt.Errorf("Missing required node %v", r.Author)
+	} else if err != nil {
+		return err
+	}
+	target, has, err := store.Get(&r.Target)
+	if !has {
+		return fmt.Errorf("Missing required node %v", r.Target)
+	} else if err != nil {
+		return err
+	}
+	if _, ok := target.(*Reply); !ok {
+		return fmt.Errorf("Reaction target must be a reply, got %T", target)
+	}
+	return nil
+}
+
+type reactionJSON struct {
+	nodeJSON
+	Target  *fields.QualifiedHash    `json:"target"`
+	Content *fields.QualifiedContent `json:"content"`
+}
+
+// MarshalJSON converts the Reaction into its canonical JSON form.
+func (r *Reaction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(reactionJSON{
+		nodeJSON: r.commonNode.toJSON(),
+		Target:   &r.Target,
+		Content:  &r.Content,
+	})
+}
+
+// UnmarshalJSON populates the Reaction from its canonical JSON form. It fails
+// unless the ID within the JSON matches the Reaction's contents. Its signature
+// cannot be checked without its author, so use UnmarshalJSONNode to decode
+// untrusted nodes.
+func (r *Reaction) UnmarshalJSON(b []byte) error {
+	var in reactionJSON
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	if err := requireJSONFields([]jsonField{
+		{"target", in.Target != nil},
+		{"content", in.Content != nil},
+	}); err != nil {
+		return err
+	}
+	if err := r.commonNode.fromJSON(&in.nodeJSON, fields.NodeTypeReaction); err != nil {
+		return err
+	}
+	r.Target = *in.Target
+	r.Content = *in.Content
+	return finishJSON(r, &r.commonNode, in.ID)
+}
+
+// TallyReactions counts the reactions to the node with the given ID, returning
+// the number of distinct authors that reacted with each reaction. Reactions
+// that have been retracted are not counted. Signatures are not checked, so the
+// store should only contain validated nodes (see ValidatingStore).
+func TallyReactions(store QueryStore, target *fields.QualifiedHash) (map[string]int, error) {
+	page, err := store.Query(&Query{Target: target})
+	if err != nil {
+		return nil, err
+	}
+	// the set of authors for each reaction
+	authors := make(map[string]map[string]struct{})
+	for _, node := range page.Nodes {
+		reaction, ok := node.(*Reaction)
+		if !ok {
+			continue
+		}
+		if retracted, err := IsRetracted(store, reaction); err != nil {
+			return nil, err
+		} else if retracted {
+			continue
+		}
+		author, err := reaction.Author.MarshalString()
+		if err != nil {
+			return nil, err
+		}
+		text := string(reaction.Content.Blob)
+		if authors[text] == nil {
+			authors[text] = make(map[string]struct{})
+		}
+		authors[text][author] = struct{}{}
+	}
+	tally := make(map[string]int, len(authors))
+	for text, reactors := range authors {
+		tally[text] = len(reactors)
+	}
+	return tally, nil
+}
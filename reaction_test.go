@@ -0,0 +1,94 @@
+package forest_test
+
+import (
+	"strings"
+	"testing"
+
+	forest "git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+func newReactionOrSkip(t *testing.T, builder *forest.Builder, target *forest.Reply, text string) *forest.Reaction {
+	content := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte(text))
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	reaction, err := builder.NewReaction(target, content, metadata)
+	if err != nil {
+		t.Skip("Failed to create reaction", err)
+	}
+	return reaction
+}
+
+func TestReactionRoundTrip(t *testing.T) {
+	identity, signer, community, reply := MakeValidReplyOrSkip(t)
+	reaction := newReactionOrSkip(t, forest.As(identity, signer), reply, "👍")
+	s := forest.NewValidatingStore(forest.NewMemoryStore())
+	for _, node := range []forest.Node{identity, community, reply, reaction} {
+		if err := s.Add(node); err != nil {
+			t.Errorf("Store should accept valid node, got %v", err)
+		}
+	}
+	if children, err := s.Children(reply.ID()); err != nil || len(children) != 0 {
+		t.Errorf("Reactions should not be children of the reply, got %d children (err: %v)", len(children), err)
+	}
+	assertRoundTrips(t, s, reaction)
+}
+
+func TestReactionContent(t *testing.T) {
+	identity, signer, _, reply := MakeValidReplyOrSkip(t)
+	builder := forest.As(identity, signer)
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	invalid := []*fields.QualifiedContent{
+		QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte{}),
+		QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte(strings.Repeat("a", forest.MaxReactionLength+1))),
+		QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte{0xff}),
+		QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("1")),
+	}
+	for _, content := range invalid {
+		if _, err := builder.NewReaction(reply, content, metadata); err == nil {
+			t.Errorf("Reaction with content %q should be invalid", content.Blob)
+		}
+	}
+	valid := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte(strings.Repeat("a", forest.MaxReactionLength)))
+	if _, err := builder.NewReaction(reply, valid, metadata); err != nil {
+		t.Errorf("Reaction with maximum length content should be valid, got %v", err)
+	}
+}
+
+func TestTallyReactions(t *testing.T) {
+	identity, signer, community, reply := MakeValidReplyOrSkip(t)
+	other, otherSigner := MakeValidIdentityOrSkip(t)
+	s := forest.NewValidatingStore(forest.NewMemoryStore())
+	for _, node := range []forest.Node{identity, other, community, reply} {
+		if err := s.Add(node); err != nil {
+			t.Skip("Failed to add node", err)
+		}
+	}
+	mine := forest.As(identity, signer)
+	theirs := forest.As(other, otherSigner)
+	retracted := newReactionOrSkip(t, theirs, reply, "👎")
+	for _, node := range []forest.Node{
+		newReactionOrSkip(t, mine, reply, "👍"),
+		newReactionOrSkip(t, mine, reply, "👍"), // duplicate from the same author
+		newReactionOrSkip(t, theirs, reply, "👍"),
+		newReactionOrSkip(t, mine, reply, "🎉"),
+		retracted,
+		newRetractionOrSkip(t, theirs, retracted),
+	} {
+		if err := s.Add(node); err != nil {
+			t.Fatalf("Failed to add node: %v", err)
+		}
+	}
+	tally, err := forest.TallyReactions(s, reply.ID())
+	if err != nil {
+		t.Fatalf("Failed to tally reactions: %v", err)
+	}
+	expected := map[string]int{"👍": 2, "🎉": 1}
+	if len(tally) != len(expected) {
+		t.Errorf("Expected tally %v, got %v", expected, tally)
+	}
+	for reaction, count := range expected {
+		if tally[reaction] != count {
+			t.Errorf("Expected %d %s reactions, got %d", count, reaction, tally[reaction])
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package forest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// Retraction nodes signal that the author of a node withdraws it, so that
+// clients can hide it. A Retraction must be signed by the author of the node
+// that it targets. Like an Edit, a Retraction has no parent and a depth of
+// zero. Use IsRetracted to determine whether a node has been retracted.
+type Retraction struct {
+	commonNode
+	Target fields.QualifiedHash
+}
+
+func newRetraction() *Retraction {
+	r := new(Retraction)
+	// define how to serialize this node type's fields
+	return r
+}
+
+// TargetID returns the ID of the node that is retracted by this node.
+func (r *Retraction) TargetID() *fields.QualifiedHash {
+	return &r.Target
+}
+
+func (r *Retraction) nodeSpecificSerializationOrder() []fields.BidirectionalBinaryMarshaler {
+	return []fields.BidirectionalBinaryMarshaler{&r.Target}
+}
+
+func (r *Retraction) SerializationOrder() []fields.BidirectionalBinaryMarshaler {
+	order := r.commonNode.presignSerializationOrder()
+	order = append(order, r.nodeSpecificSerializationOrder()...)
+	order = append(order, r.commonNode.postsignSerializationOrder()...)
+	return order
+}
+
+func (r Retraction) MarshalSignedData() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(r.presignSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(r.nodeSpecificSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (r Retraction) MarshalBinary() ([]byte, error) {
+	signed, err := r.MarshalSignedData()
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(signed)
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(r.postsignSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func UnmarshalRetraction(b []byte) (*Retraction, error) {
+	r := newRetraction()
+	if err := r.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Retraction) UnmarshalBinary(b []byte) error {
+	if err := r.unmarshalBinaryVersion(b); err != nil {
+		return err
+	}
+	if err := UnmarshalBinary(r, b); err != nil {
+		return err
+	}
+	idBytes, err := computeID(r)
+	if err != nil {
+		return err
+	}
+	r.id = fields.Blob(idBytes)
+	return nil
+}
+
+func (r *Retraction) Equals(other interface{}) bool {
+	r2, valid := other.(*Retraction)
+	if !valid {
+		return false
+	}
+	return r.commonNode.Equals(&r2.commonNode) &&
+		r.Target.Equals(&r2.Target)
+}
+
+// ValidateShallow checks all fields for internal validity. It does not check
+// the existence or validity of nodes referenced from this node.
+func (r *Retraction) ValidateShallow() error {
+	if err := r.commonNode.ValidateShallow(); err != nil {
+		return err
+	}
+	if err := r.Target.Validate(); err != nil {
+		return err
+	}
+	if !r.Parent.Equals(fields.NullHash()) {
+		return fmt.Errorf("Retraction parent must be null hash, got %v", r.Parent)
+	}
+	if r.Depth != 0 {
+		return fmt.Errorf("Retraction depth must be 0, got %d", r.Depth)
+	}
+	if r.Target.Equals(fields.NullHash()) {
+		return fmt.Errorf("Retraction target must not be null hash")
+	}
+	if r.Author.Equals(fields.NullHash()) {
+		return fmt.Errorf("Retraction author must not be null hash")
+	}
+	return nil
+}
+
+// authorOf returns the ID of the Identity that wrote the node, or nil if the
+// node has no author (as is the case for an Identity).
+func authorOf(node Node) *fields.QualifiedHash {
+	c, ok := node.(interface{ common() *commonNode })
+	if !ok || c.common().Author.Equals(fields.NullHash()) {
+		return nil
+	}
+	return &c.common().Author
+}
+
+// ValidateDeep checks that the retracted node exists within the store and was
+// written by the author of the Retraction.
+func (r *Retraction) ValidateDeep(store Store) error {
+	if _, has, err := store.Get(&r.Author); !has {
+		return fmt.Errorf("Missing required node %v", r.Author)
+	} else if err != nil {
+		return err
+	}
+	target, has, err := store.Get(&r.Target)
+	if !has {
+		return fmt.Errorf("Missing required node %v", r.Target)
+	} else if err != nil {
+		return err
+	}
+	if author := authorOf(target); author == nil || !author.Equals(&r.Author) {
+		return fmt.Errorf("Retraction author %v did not write the target node", r.Author)
+	}
+	return nil
+}
+
+type retractionJSON struct {
+	nodeJSON
+	Target *fields.QualifiedHash `json:"target"`
+}
+
+// MarshalJSON converts the Retraction into its canonical JSON form.
+func (r *Retraction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(retractionJSON{
+		nodeJSON: r.commonNode.toJSON(),
+		Target:   &r.Target,
+	})
+}
+
+// UnmarshalJSON populates the Retraction from its canonical JSON form. It fails
+// unless the ID within the JSON matches the Retraction's contents. Its
+// signature cannot be checked without its author, so use UnmarshalJSONNode to
+// decode untrusted nodes.
+func (r *Retraction) UnmarshalJSON(b []byte) error {
+	var in retractionJSON
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	if err := requireJSONFields([]jsonField{
+		{"target", in.Target != nil},
+	}); err != nil {
+		return err
+	}
+	if err := r.commonNode.fromJSON(&in.nodeJSON, fields.NodeTypeRetraction); err != nil {
+		return err
+	}
+	r.Target = *in.Target
+	return finishJSON(r, &r.commonNode, in.ID)
+}
+
+// IsRetracted returns whether the store contains a Retraction of the node
+// written by the node's author. Signatures are not checked, so the store
+// should only contain validated nodes (see ValidatingStore).
+func IsRetracted(store QueryStore, node Node) (bool, error) {
+	author := authorOf(node)
+	if author == nil {
+		return false, nil
+	}
+	page, err := store.Query(&Query{Target: node.ID()})
+	if err != nil {
+		return false, err
+	}
+	for _, candidate := range page.Nodes {
+		if retraction, ok := candidate.(*Retraction); ok && retraction.Author.Equals(author) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,68 @@
+package forest_test
+
+import (
+	"testing"
+
+	forest "git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+func newRetractionOrSkip(t *testing.T, builder *forest.Builder, target forest.Node) *forest.Retraction {
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	retraction, err := builder.NewRetraction(target, metadata)
+	if err != nil {
+		t.Skip("Failed to create retraction", err)
+	}
+	return retraction
+}
+
+func TestRetraction(t *testing.T) {
+	identity, signer, community, reply := MakeValidReplyOrSkip(t)
+	sibling := newReplyOrSkip(t, forest.As(identity, signer), community, "sibling")
+	s := forest.NewValidatingStore(forest.NewMemoryStore())
+	for _, node := range []forest.Node{identity, community, reply, sibling} {
+		if err := s.Add(node); err != nil {
+			t.Skip("Failed to add node", err)
+		}
+	}
+	for _, target := range []forest.Node{reply, community} {
+		retraction := newRetractionOrSkip(t, forest.As(identity, signer), target)
+		if err := s.Add(retraction); err != nil {
+			t.Errorf("Store should accept valid retraction, got %v", err)
+		}
+		if retracted, err := forest.IsRetracted(s, target); err != nil || !retracted {
+			t.Errorf("Expected %T to be retracted (err: %v)", target, err)
+		}
+		assertRoundTrips(t, s, retraction)
+	}
+	for _, node := range []forest.Node{identity, sibling} {
+		if retracted, err := forest.IsRetracted(s, node); err != nil || retracted {
+			t.Errorf("Expected %T not to be retracted (err: %v)", node, err)
+		}
+	}
+}
+
+func TestRetractionRequiresAuthor(t *testing.T) {
+	identity, _, community, reply := MakeValidReplyOrSkip(t)
+	other, otherSigner := MakeValidIdentityOrSkip(t)
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	for _, target := range []forest.Node{reply, identity, other} {
+		if _, err := forest.As(other, otherSigner).NewRetraction(target, metadata); err == nil {
+			t.Errorf("Builder should refuse to retract %T", target)
+		}
+	}
+	s := forest.NewValidatingStore(forest.NewMemoryStore())
+	for _, node := range []forest.Node{identity, other, community, reply} {
+		if err := s.Add(node); err != nil {
+			t.Skip("Failed to add node", err)
+		}
+	}
+	// claim that the other user wrote the reply without changing its ID
+	disguised := *reply
+	disguised.Author = *other.ID()
+	forged := newRetractionOrSkip(t, forest.As(other, otherSigner), &disguised)
+	expectValidationFailure(t, s.Add(forged), forest.CheckReferences)
+	if retracted, err := forest.IsRetracted(s, reply); err != nil || retracted {
+		t.Errorf("Rejected retraction should not retract the reply (err: %v)", err)
+	}
+}
@@ -0,0 +1,429 @@
+package forest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// Role nodes change the role of an Identity, the subject, within a Community.
+// The author of the Community can grant and revoke the moderator role, and
+// both the author and the moderators can ban and unban identities. Roles
+// written by anyone else have no effect. Roles are applied in the order that
+// they were created, so they must be written with a schema version that
+// records creation times. Like an Edit, a Role has no parent and a depth of
+// zero. Use a CommunityPolicy to evaluate the Roles within a Community.
+type Role struct {
+	commonNode
+	Community fields.QualifiedHash
+	Subject   fields.QualifiedHash
+	Action    fields.RoleAction
+}
+
+func newRole() *Role {
+	r := new(Role)
+	// define how to serialize this node type's fields
+	return r
+}
+
+// TargetID returns the ID of the Community that this node applies to.
+func (r *Role) TargetID() *fields.QualifiedHash {
+	return &r.Community
+}
+
+func (r *Role) nodeSpecificSerializationOrder() []fields.BidirectionalBinaryMarshaler {
+	return []fields.BidirectionalBinaryMarshaler{&r.Community, &r.Subject, &r.Action}
+}
+
+func (r *Role) SerializationOrder() []fields.BidirectionalBinaryMarshaler {
+	order := r.commonNode.presignSerializationOrder()
+	order = append(order, r.nodeSpecificSerializationOrder()...)
+	order = append(order, r.commonNode.postsignSerializationOrder()...)
+	return order
+}
+
+func (r Role) MarshalSignedData() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(r.presignSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(r.nodeSpecificSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (r Role) MarshalBinary() ([]byte, error) {
+	signed, err := r.MarshalSignedData()
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(signed)
+	if err := fields.MarshalAllInto(buf, fields.AsMarshaler(r.postsignSerializationOrder())...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func UnmarshalRole(b []byte) (*Role, error) {
+	r := newRole()
+	if err := r.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Role) UnmarshalBinary(b []byte) error {
+	if err := r.unmarshalBinaryVersion(b); err != nil {
+		return err
+	}
+	if err := UnmarshalBinary(r, b); err != nil {
+		return err
+	}
+	idBytes, err := computeID(r)
+	if err != nil {
+		return err
+	}
+	r.id = fields.Blob(idBytes)
+	return nil
+}
+
+func (r *Role) Equals(other interface{}) bool {
+	r2, valid := other.(*Role)
+	if !valid {
+		return false
+	}
+	return r.commonNode.Equals(&r2.commonNode) &&
+		r.Community.Equals(&r2.Community) &&
+		r.Subject.Equals(&r2.Subject) &&
+		r.Action.Equals(&r2.Action)
+}
+
+// ValidateShallow checks all fields for internal validity. It does not check
+// the existence or validity of nodes referenced from this node.
+func (r *Role) ValidateShallow() error {
+	if err := r.commonNode.ValidateShallow(); err != nil {
+		return err
+	}
+	needsValidation := []Validator{&r.Community, &r.Subject}
+	for _, nv := range needsValidation {
+		if err := nv.Validate(); err != nil {
+			return err
+		}
+	}
+	if _, valid := fields.ValidRoleActions[r.Action]; !valid {
+		return fmt.Errorf("%d is not a valid role action", r.Action)
+	}
+	if s, err := schemaFor(r.SchemaVersion); err != nil || !s.timestamps {
+		return fmt.Errorf("Roles must record their creation time, which schema version %d does not", r.SchemaVersion)
+	}
+	if !r.Parent.Equals(fields.NullHash()) {
+		return fmt.Errorf("Role parent must be null hash, got %v", r.Parent)
+	}
+	if r.Depth != 0 {
+		return fmt.Errorf("Role depth must be 0, got %d", r.Depth)
+	}
+	if r.Community.Equals(fields.NullHash()) {
+		return fmt.Errorf("Role community must not be null hash")
+	}
+	if r.Subject.Equals(fields.NullHash()) {
+		return fmt.Errorf("Role subject must not be null hash")
+	}
+	if r.Author.Equals(fields.NullHash()) {
+		return fmt.Errorf("Role author must not be null hash")
+	}
+	return nil
+}
+
+// ValidateDeep checks that the author, the Community, and the subject exist
+// within the store, and that only the author of the Community grants or
+// revokes the moderator role. Whether the author of a ban may issue it
+// depends upon the other Roles within the Community, so it is checked by a
+// CommunityPolicy instead.
+func (r *Role) ValidateDeep(store Store) error {
+	if _, has, err := store.Get(&r.Author); !has {
+		return fmt.Errorf("Missing required node %v", r.Author)
+	} else if err != nil {
+		return err
+	}
+	communityNode, has, err := store.Get(&r.Community)
+	if !has {
+		return fmt.Errorf("Missing required node %v", r.Community)
+	} else if err != nil {
+		return err
+	}
+	community, ok := communityNode.(*Community)
+	if !ok {
+		return fmt.Errorf("Role community must be a community, got %T", communityNode)
+	}
+	subject, has, err := store.Get(&r.Subject)
+	if !has {
+		return fmt.Errorf("Missing required node %v", r.Subject)
+	} else if err != nil {
+		return err
+	}
+	if _, ok := subject.(*Identity); !ok {
+		return fmt.Errorf("Role subject must be an identity, got %T", subject)
+	}
+	if r.Subject.Equals(&community.Author) {
+		return fmt.Errorf("The role of the author of a community cannot be changed")
+	}
+	switch r.Action {
+	case fields.RoleActionGrantModerator, fields.RoleActionRevokeModerator:
+		if !r.Author.Equals(&community.Author) {
+			return fmt.Errorf("Only the author of a community can change its moderators")
+		}
+	}
+	return r.validateCreatedAfter(community)
+}
+
+type roleJSON struct {
+	nodeJSON
+	Community *fields.QualifiedHash `json:"community"`
+	Subject   *fields.QualifiedHash `json:"subject"`
+	Action    *fields.RoleAction    `json:"action"`
+}
+
+// MarshalJSON converts the Role into its canonical JSON form.
+func (r *Role) MarshalJSON() ([]byte, error) {
+	return json.Marshal(roleJSON{
+		nodeJSON:  r.commonNode.toJSON(),
+		Community: &r.Community,
+		Subject:   &r.Subject,
+		Action:    &r.Action,
+	})
+}
+
+// UnmarshalJSON populates the Role from its canonical JSON form. It fails
+// unless the ID within the JSON matches the Role's contents. Its signature
+// cannot be checked without its author, so use UnmarshalJSONNode to decode
+// untrusted nodes.
+func (r *Role) UnmarshalJSON(b []byte) error {
+	var in roleJSON
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	if err := requireJSONFields([]jsonField{
+		{"community", in.Community != nil},
+		{"subject", in.Subject != nil},
+		{"action", in.Action != nil},
+	}); err != nil {
+		return err
+	}
+	if err := r.commonNode.fromJSON(&in.nodeJSON, fields.NodeTypeRole); err != nil {
+		return err
+	}
+	r.Community = *in.Community
+	r.Subject = *in.Subject
+	r.Action = *in.Action
+	return finishJSON(r, &r.commonNode, in.ID)
+}
+
+// CommunityRoles is the state of the roles within a Community after applying
+// its Roles in order.
+type CommunityRoles struct {
+	Community *Community
+	// moderators and banned hold the text form of the IDs of the identities
+	// with each role
+	moderators map[string]struct{}
+	banned     map[string]struct{}
+}
+
+// LoadCommunityRoles applies the Roles within the community that are stored
+// in the store. Roles are applied in the order that they were created, with
+// ties broken by the text form of their IDs, and Roles written by an identity
+// without the authority to apply them are ignored. Signatures are not
+// checked, so the store should only contain validated nodes (see
+// ValidatingStore).
+func LoadCommunityRoles(store QueryStore, community *Community) (*CommunityRoles, error) {
+	return loadCommunityRoles(store, community, func(*Role) bool { return true })
+}
+
+// loadCommunityRoles applies the Roles within the community like
+// LoadCommunityRoles, but ignores the Roles for which include returns false.
+func loadCommunityRoles(store QueryStore, community *Community, include func(*Role) bool) (*CommunityRoles, error) {
+	page, err := store.Query(&Query{Target: community.ID()})
+	if err != nil {
+		return nil, err
+	}
+	var roles []*Role
+	for _, node := range page.Nodes {
+		if role, ok := node.(*Role); ok && role.Community.Equals(community.ID()) && include(role) {
+			roles = append(roles, role)
+		}
+	}
+	sort.Slice(roles, func(i, j int) bool {
+		return isNewer(&roles[j].commonNode, &roles[i].commonNode)
+	})
+	state := &CommunityRoles{
+		Community:  community,
+		moderators: make(map[string]struct{}),
+		banned:     make(map[string]struct{}),
+	}
+	for _, role := range roles {
+		if err := state.authorize(role); err != nil {
+			continue
+		}
+		if err := state.apply(role); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// hasRole returns whether the identity is within the set of identities.
+func hasRole(set map[string]struct{}, identity *fields.QualifiedHash) bool {
+	id, err := identity.MarshalString()
+	if err != nil {
+		return false
+	}
+	_, ok := set[id]
+	return ok
+}
+
+// IsModerator returns whether the identity moderates the Community. The author
+// of the Community is always a moderator.
+func (c *CommunityRoles) IsModerator(identity *fields.QualifiedHash) bool {
+	return identity.Equals(&c.Community.Author) || hasRole(c.moderators, identity)
+}
+
+// IsBanned returns whether the identity is banned from the Community.
+func (c *CommunityRoles) IsBanned(identity *fields.QualifiedHash) bool {
+	return hasRole(c.banned, identity)
+}
+
+// CanPost returns whether the identity may post Replies within the Community.
+// Every identity that has not been banned may post.
+func (c *CommunityRoles) CanPost(identity *fields.QualifiedHash) bool {
+	return !c.IsBanned(identity)
+}
+
+// authorize returns an error if the author of the role does not have the
+// authority to apply it.
+func (c *CommunityRoles) authorize(role *Role) error {
+	if !role.Community.Equals(c.Community.ID()) {
+		return fmt.Errorf("Role applies to a different community")
+	}
+	if role.Subject.Equals(&c.Community.Author) {
+		return fmt.Errorf("The role of the author of a community cannot be changed")
+	}
+	owner := role.Author.Equals(&c.Community.Author)
+	switch role.Action {
+	case fields.RoleActionGrantModerator, fields.RoleActionRevokeModerator:
+		if !owner {
+			return fmt.Errorf("Only the author of a community can change its moderators")
+		}
+	case fields.RoleActionBan, fields.RoleActionUnban:
+		if !c.IsModerator(&role.Author) {
+			return fmt.Errorf("Only moderators of a community can ban identities")
+		}
+		if !owner && c.IsModerator(&role.Subject) {
+			return fmt.Errorf("Only the author of a community can ban its moderators")
+		}
+	default:
+		return fmt.Errorf("%d is not a valid role action", role.Action)
+	}
+	return nil
+}
+
+// apply updates the state of the Community with the role.
+func (c *CommunityRoles) apply(role *Role) error {
+	subject, err := role.Subject.MarshalString()
+	if err != nil {
+		return err
+	}
+	switch role.Action {
+	case fields.RoleActionGrantModerator:
+		c.moderators[subject] = struct{}{}
+	case fields.RoleActionRevokeModerator:
+		delete(c.moderators, subject)
+	case fields.RoleActionBan:
+		c.banned[subject] = struct{}{}
+	case fields.RoleActionUnban:
+		delete(c.banned, subject)
+	}
+	return nil
+}
+
+// CommunityPolicy evaluates the Roles within the Communities of a store. It
+// can be used as the Policy of a ValidatingStore, in which case it rejects
+// Replies by banned identities and Roles written without the authority to
+// apply them.
+//
+// Nodes are checked against the Roles that were created before them rather
+// than the Roles that happen to be in the store when they arrive, so stores
+// that receive the same nodes in different orders accept the same nodes.
+// The creation time of a node is chosen by its author, though, so a banned
+// identity can still post Replies that claim to predate its ban. Replies
+// without a creation time are checked against every Role in the store.
+type CommunityPolicy struct {
+	Store QueryStore
+}
+
+// NewCommunityPolicy creates a CommunityPolicy that evaluates the Roles within
+// the given store.
+func NewCommunityPolicy(store QueryStore) *CommunityPolicy {
+	return &CommunityPolicy{Store: store}
+}
+
+// Roles returns the current state of the roles within the Community with the
+// given ID.
+func (p *CommunityPolicy) Roles(community *fields.QualifiedHash) (*CommunityRoles, error) {
+	return p.loadRoles(community, func(*Role) bool { return true })
+}
+
+// CanPost returns whether the identity may post Replies within the Community.
+func (p *CommunityPolicy) CanPost(identity, community *fields.QualifiedHash) (bool, error) {
+	roles, err := p.Roles(community)
+	if err != nil {
+		return false, err
+	}
+	return roles.CanPost(identity), nil
+}
+
+// loadRoles returns the state of the roles within the Community with the
+// given ID after applying only the Roles for which include returns true.
+func (p *CommunityPolicy) loadRoles(community *fields.QualifiedHash, include func(*Role) bool) (*CommunityRoles, error) {
+	node, has, err := p.Store.Get(community)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, fmt.Errorf("Missing community %v", community)
+	}
+	c, ok := node.(*Community)
+	if !ok {
+		return nil, fmt.Errorf("Expected a community, got %T", node)
+	}
+	return loadCommunityRoles(p.Store, c, include)
+}
+
+// Allow returns an error if the node is a Reply by an identity that was
+// banned from its Community when the Reply was created, or a Role that its
+// author did not have the authority to apply when it was created.
+func (p *CommunityPolicy) Allow(node Node) error {
+	switch n := node.(type) {
+	case *Reply:
+		roles, err := p.loadRoles(&n.CommunityID, func(r *Role) bool {
+			return n.Created == 0 || r.Created <= n.Created
+		})
+		if err != nil {
+			return err
+		}
+		if !roles.CanPost(&n.Author) {
+			return fmt.Errorf("Identity %v is banned from community %v", n.Author, n.CommunityID)
+		}
+	case *Role:
+		// apply the same Roles that precede n within LoadCommunityRoles
+		roles, err := p.loadRoles(&n.Community, func(r *Role) bool {
+			return isNewer(&n.commonNode, &r.commonNode)
+		})
+		if err != nil {
+			return err
+		}
+		return roles.authorize(n)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package forest_test
+
+import (
+	"testing"
+	"time"
+
+	forest "git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// roleFixture holds a community, its author, and other identities that can
+// be given roles within it. Each builder creates nodes one second after the
+// previous node so that roles are applied in the order they are created.
+type roleFixture struct {
+	t         *testing.T
+	store     *forest.ValidatingStore
+	community *forest.Community
+	owner     *forest.Identity
+	signers   map[*forest.Identity]forest.Signer
+	now       time.Time
+}
+
+func newRoleFixtureOrSkip(t *testing.T, policy bool) *roleFixture {
+	owner, signer, community, _ := MakeValidReplyOrSkip(t)
+	f := &roleFixture{
+		t:         t,
+		store:     forest.NewValidatingStore(forest.NewMemoryStore()),
+		community: community,
+		owner:     owner,
+		signers:   map[*forest.Identity]forest.Signer{owner: signer},
+		now:       time.Now(),
+	}
+	if policy {
+		f.store.Policy = forest.NewCommunityPolicy(f.store)
+	}
+	for _, node := range []forest.Node{owner, community} {
+		if err := f.store.Add(node); err != nil {
+			t.Skip("Failed to add node", err)
+		}
+	}
+	return f
+}
+
+func (f *roleFixture) newIdentity() *forest.Identity {
+	identity, signer := MakeValidIdentityOrSkip(f.t)
+	if err := f.store.Add(identity); err != nil {
+		f.t.Skip("Failed to add identity", err)
+	}
+	f.signers[identity] = signer
+	return identity
+}
+
+func (f *roleFixture) as(identity *forest.Identity) *forest.Builder {
+	f.now = f.now.Add(time.Second)
+	return builderAt(identity, f.signers[identity], f.now)
+}
+
+func (f *roleFixture) role(author, subject *forest.Identity, action fields.RoleAction) *forest.Role {
+	metadata := QualifiedContentOrSkip(f.t, fields.ContentTypeJSON, []byte("{}"))
+	role, err := f.as(author).NewRole(f.community, subject, action, metadata)
+	if err != nil {
+		f.t.Skip("Failed to create role", err)
+	}
+	return role
+}
+
+func (f *roleFixture) post(author *forest.Identity) error {
+	return f.store.Add(newReplyOrSkip(f.t, f.as(author), f.community, "post"))
+}
+
+func TestCommunityPolicyBans(t *testing.T) {
+	f := newRoleFixtureOrSkip(t, true)
+	moderator, member := f.newIdentity(), f.newIdentity()
+	if err := f.post(member); err != nil {
+		t.Errorf("Identity that was not banned should be able to post, got %v", err)
+	}
+	if err := f.store.Add(f.role(f.owner, moderator, fields.RoleActionGrantModerator)); err != nil {
+		t.Fatalf("Community author should be able to grant moderator role, got %v", err)
+	}
+	if err := f.store.Add(f.role(moderator, member, fields.RoleActionBan)); err != nil {
+		t.Fatalf("Moderator should be able to ban, got %v", err)
+	}
+	expectValidationFailure(t, f.post(member), forest.CheckPolicy)
+	policy := forest.NewCommunityPolicy(f.store)
+	if allowed, err := policy.CanPost(member.ID(), f.community.ID()); err != nil || allowed {
+		t.Errorf("Banned identity should not be able to post (err: %v)", err)
+	}
+	if err := f.store.Add(f.role(moderator, member, fields.RoleActionUnban)); err != nil {
+		t.Fatalf("Moderator should be able to unban, got %v", err)
+	}
+	if err := f.post(member); err != nil {
+		t.Errorf("Unbanned identity should be able to post, got %v", err)
+	}
+}
+
+func TestCommunityPolicyRequiresAuthority(t *testing.T) {
+	f := newRoleFixtureOrSkip(t, true)
+	moderator, other, member := f.newIdentity(), f.newIdentity(), f.newIdentity()
+	for _, m := range []*forest.Identity{moderator, other} {
+		if err := f.store.Add(f.role(f.owner, m, fields.RoleActionGrantModerator)); err != nil {
+			t.Fatalf("Community author should be able to grant moderator role, got %v", err)
+		}
+	}
+	expectValidationFailure(t, f.store.Add(f.role(member, moderator, fields.RoleActionBan)), forest.CheckPolicy)
+	expectValidationFailure(t, f.store.Add(f.role(moderator, other, fields.RoleActionBan)), forest.CheckPolicy)
+	expectValidationFailure(t, f.store.Add(f.role(moderator, member, fields.RoleActionGrantModerator)), forest.CheckReferences)
+	expectValidationFailure(t, f.store.Add(f.role(moderator, f.owner, fields.RoleActionBan)), forest.CheckReferences)
+	if err := f.store.Add(f.role(f.owner, other, fields.RoleActionRevokeModerator)); err != nil {
+		t.Fatalf("Community author should be able to revoke moderator role, got %v", err)
+	}
+	if err := f.store.Add(f.role(moderator, other, fields.RoleActionBan)); err != nil {
+		t.Errorf("Moderator should be able to ban a former moderator, got %v", err)
+	}
+}
+
+func TestLoadCommunityRolesIgnoresUnauthorizedRoles(t *testing.T) {
+	f := newRoleFixtureOrSkip(t, false)
+	moderator, member := f.newIdentity(), f.newIdentity()
+	// without a policy, the store accepts roles without checking authority
+	for _, role := range []*forest.Role{
+		f.role(moderator, member, fields.RoleActionBan),
+		f.role(f.owner, moderator, fields.RoleActionGrantModerator),
+		f.role(member, moderator, fields.RoleActionBan),
+	} {
+		if err := f.store.Add(role); err != nil {
+			t.Fatalf("Failed to add role: %v", err)
+		}
+	}
+	roles, err := forest.LoadCommunityRoles(f.store, f.community)
+	if err != nil {
+		t.Fatalf("Failed to load roles: %v", err)
+	}
+	if !roles.IsModerator(moderator.ID()) || !roles.IsModerator(f.owner.ID()) {
+		t.Errorf("Expected the community author and the granted identity to be moderators")
+	}
+	if roles.IsBanned(member.ID()) {
+		t.Errorf("Ban issued before its author was a moderator should be ignored")
+	}
+	if roles.IsBanned(moderator.ID()) {
+		t.Errorf("Ban issued by an identity that is not a moderator should be ignored")
+	}
+}
+
+func TestRoleRoundTrip(t *testing.T) {
+	f := newRoleFixtureOrSkip(t, true)
+	role := f.role(f.owner, f.newIdentity(), fields.RoleActionBan)
+	if err := f.store.Add(role); err != nil {
+		t.Fatalf("Store should accept valid role, got %v", err)
+	}
+	assertRoundTrips(t, f.store, role)
+}
+
+func TestRoleRequiresTimestamps(t *testing.T) {
+	f := newRoleFixtureOrSkip(t, true)
+	member := f.newIdentity()
+	builder := f.as(f.owner)
+	builder.SchemaVersion = fields.VersionInitial
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	if _, err := builder.NewRole(f.community, member, fields.RoleActionBan, metadata); err == nil {
+		t.Errorf("Role without a creation time should be invalid")
+	}
+}
+
+func TestCommunityPolicyUsesCreationTime(t *testing.T) {
+	f := newRoleFixtureOrSkip(t, true)
+	member := f.newIdentity()
+	early := newReplyOrSkip(t, f.as(member), f.community, "before the ban")
+	if err := f.store.Add(f.role(f.owner, member, fields.RoleActionBan)); err != nil {
+		t.Fatalf("Community author should be able to ban, got %v", err)
+	}
+	// the reply arrives after the ban, but was created before it
+	if err := f.store.Add(early); err != nil {
+		t.Errorf("Reply created before the ban should be accepted, got %v", err)
+	}
+	expectValidationFailure(t, f.post(member), forest.CheckPolicy)
+
+	// a store that receives the reply before the ban holds the same nodes
+	other := forest.NewValidatingStore(forest.NewMemoryStore())
+	other.Policy = forest.NewCommunityPolicy(other)
+	page, err := f.store.Query(&forest.Query{Target: f.community.ID()})
+	if err != nil {
+		t.Fatalf("Failed to query roles: %v", err)
+	}
+	for _, node := range append([]forest.Node{f.owner, member, f.community, early}, page.Nodes...) {
+		if err := other.Add(node); err != nil {
+			t.Errorf("Store should accept %T regardless of arrival order, got %v", node, err)
+		}
+	}
+}
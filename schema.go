@@ -0,0 +1,84 @@
+package forest
+
+import (
+	"fmt"
+	"sort"
+
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// schema describes the binary layout and validation rules of the fields
+// shared by every node type within one version of the Forest. The fields
+// specific to each node type are currently the same in every version.
+type schema struct {
+	// presignSerializationOrder lists the common fields that precede the
+	// node-specific fields. The first two must always be the version and the
+	// node type so that VersionAndNodeTypeOf can read them from any node.
+	presignSerializationOrder func(n *commonNode) []fields.BidirectionalBinaryMarshaler
+	// validateShallow applies the version-specific rules to the common fields
+	validateShallow func(n *commonNode) error
+	// timestamps is whether nodes of this version record their creation time
+	timestamps bool
+}
+
+// schemas holds every version of the Forest that this library can read
+// and write.
+var schemas = map[fields.Version]*schema{
+	fields.VersionInitial: {
+		presignSerializationOrder: presignSerializationOrderV1,
+		validateShallow:           func(*commonNode) error { return nil },
+	},
+	fields.VersionTimestamps: {
+		presignSerializationOrder: func(n *commonNode) []fields.BidirectionalBinaryMarshaler {
+			return append(presignSerializationOrderV1(n), &n.Created)
+		},
+		validateShallow: func(n *commonNode) error {
+			if n.Created == 0 {
+				return fmt.Errorf("Nodes of version %d must have a creation time", n.SchemaVersion)
+			}
+			return nil
+		},
+		timestamps: true,
+	},
+}
+
+func presignSerializationOrderV1(n *commonNode) []fields.BidirectionalBinaryMarshaler {
+	order := []fields.BidirectionalBinaryMarshaler{
+		&n.SchemaVersion,
+		&n.Type,
+	}
+	order = append(order, &n.Parent)
+	order = append(order, n.IDDesc.SerializationOrder()...)
+	order = append(order, &n.Depth)
+	order = append(order, &n.Metadata)
+	order = append(order, &n.Author)
+	return order
+}
+
+// SupportedVersions returns every schema version that can be read and
+// written, in ascending order.
+func SupportedVersions() []fields.Version {
+	versions := make([]fields.Version, 0, len(schemas))
+	for version := range schemas {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] < versions[j]
+	})
+	return versions
+}
+
+// schemaFor returns the schema for the given version.
+func schemaFor(v fields.Version) (*schema, error) {
+	s, ok := schemas[v]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported schema version %d, supported versions are %v", v, SupportedVersions())
+	}
+	return s, nil
+}
+
+// validateVersion ensures that nodes can be written with the given version.
+func validateVersion(v fields.Version) error {
+	_, err := schemaFor(v)
+	return err
+}
@@ -0,0 +1,92 @@
+package forest_test
+
+import (
+	"testing"
+
+	forest "git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+	"golang.org/x/crypto/openpgp"
+)
+
+func TestSupportedVersions(t *testing.T) {
+	versions := forest.SupportedVersions()
+	if len(versions) != 2 || versions[0] != fields.VersionInitial || versions[1] != fields.VersionTimestamps {
+		t.Errorf("Expected versions %d and %d to be supported, got %v", fields.VersionInitial, fields.VersionTimestamps, versions)
+	}
+	if versions[len(versions)-1] != fields.CurrentVersion {
+		t.Errorf("Expected the current version to be the newest supported version")
+	}
+}
+
+func makeVersionedNodesOrSkip(t *testing.T, identityVersion, version fields.Version) []forest.Node {
+	privkey, err := openpgp.NewEntity("forest-test", "comment", "email@email.io", nil)
+	if err != nil {
+		t.Skip("Failed to create private key", err)
+	}
+	signer, err := forest.NewNativeSigner(privkey)
+	if err != nil {
+		t.Skip("Failed to create signer", err)
+	}
+	name := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte("name"))
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	identity, err := forest.NewIdentityVersion(signer, name, metadata, identityVersion)
+	if err != nil {
+		t.Fatalf("Failed to create version %d identity: %v", identityVersion, err)
+	}
+	builder := forest.As(identity, signer)
+	builder.SchemaVersion = version
+	community, err := builder.NewCommunity(name, metadata)
+	if err != nil {
+		t.Fatalf("Failed to create version %d community: %v", version, err)
+	}
+	reply, err := builder.NewReply(community, name, metadata)
+	if err != nil {
+		t.Fatalf("Failed to create version %d reply: %v", version, err)
+	}
+	return []forest.Node{identity, community, reply}
+}
+
+func TestReadAndWriteEveryVersion(t *testing.T) {
+	for _, identityVersion := range forest.SupportedVersions() {
+		for _, version := range forest.SupportedVersions() {
+			nodes := makeVersionedNodesOrSkip(t, identityVersion, version)
+			if nodes[2].(*forest.Reply).SchemaVersion != version {
+				t.Errorf("Builder should write version %d nodes", version)
+			}
+			s := forest.NewValidatingStore(forest.NewMemoryStore())
+			for _, node := range nodes {
+				if err := s.Add(node); err != nil {
+					t.Errorf("Version %d node should be valid, got %v", version, err)
+				}
+			}
+			assertRoundTrips(t, s, nodes...)
+		}
+	}
+}
+
+func TestUnsupportedVersions(t *testing.T) {
+	identity, signer := MakeValidIdentityOrSkip(t)
+	name := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte("name"))
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	if _, err := forest.NewIdentityVersion(signer, name, metadata, fields.CurrentVersion+1); err == nil {
+		t.Errorf("Creating an identity with an unsupported version should fail")
+	}
+	builder := forest.As(identity, signer)
+	builder.SchemaVersion = fields.CurrentVersion + 1
+	if _, err := builder.NewCommunity(name, metadata); err == nil {
+		t.Errorf("Creating a community with an unsupported version should fail")
+	}
+	b, err := identity.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal identity: %v", err)
+	}
+	for _, version := range []byte{0, byte(fields.CurrentVersion + 1)} {
+		b[7] = version
+		if _, err := forest.UnmarshalBinaryNode(b); err == nil {
+			t.Errorf("Unmarshaling a node of version %d should fail", version)
+		}
+		if _, err := forest.UnmarshalIdentity(b); err == nil {
+			t.Errorf("Unmarshaling an identity of version %d should fail", version)
+		}
+	}
+}
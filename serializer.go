@@ -2,6 +2,7 @@ package forest
 
 import (
 	"bytes"
+	"fmt"
 
 	"git.sr.ht/~whereswaldon/forest-go/fields"
 )
@@ -19,10 +20,29 @@ func MarshalBinary(s serializer) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// TrailingDataError is returned when binary input contains data after the end
+// of the value being unmarshaled.
+type TrailingDataError struct {
+	// Consumed is the number of bytes that made up the value
+	Consumed int
+	// Length is the total number of bytes in the input
+	Length int
+}
+
+func (e *TrailingDataError) Error() string {
+	return fmt.Sprintf("Unexpected %d bytes of trailing data after byte offset %d", e.Length-e.Consumed, e.Consumed)
+}
+
+// UnmarshalBinary unmarshals every field of s from b. If b contains data after
+// the last field, a *TrailingDataError is returned.
 func UnmarshalBinary(s serializer, b []byte) error {
-	if _, err := fields.UnmarshalAll(b, fields.AsUnmarshaler(s.SerializationOrder())...); err != nil {
+	unused, err := fields.UnmarshalAll(b, fields.AsUnmarshaler(s.SerializationOrder())...)
+	if err != nil {
 		return err
 	}
+	if len(unused) > 0 {
+		return &TrailingDataError{Consumed: len(b) - len(unused), Length: len(b)}
+	}
 	return nil
 }
 
@@ -2,7 +2,9 @@ package forest
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"fmt"
+	"sync"
 
 	"golang.org/x/crypto/openpgp"
 	"golang.org/x/crypto/openpgp/packet"
@@ -18,10 +20,99 @@ type SignatureValidator interface {
 	IsIdentity() bool
 }
 
+// Verifier checks signatures made by a particular type of key.
+type Verifier interface {
+	// Verify returns nil if signature is a valid signature of data made by
+	// the private counterpart of key.
+	Verify(key *fields.QualifiedKey, data []byte, signature *fields.QualifiedSignature) error
+}
+
+// VerifierFunc allows an ordinary function to be used as a Verifier.
+type VerifierFunc func(key *fields.QualifiedKey, data []byte, signature *fields.QualifiedSignature) error
+
+// Verify invokes the function.
+func (f VerifierFunc) Verify(key *fields.QualifiedKey, data []byte, signature *fields.QualifiedSignature) error {
+	return f(key, data, signature)
+}
+
+// SignerFactory creates a Signer from the serialized form of a private key.
+type SignerFactory func(privateKey []byte) (Signer, error)
+
+type signatureScheme struct {
+	keyType       fields.KeyType
+	signatureType fields.SignatureType
+}
+
+// SignatureRegistry associates key and signature types with the means to
+// verify and create signatures of those types. New types of key and signature
+// must also be made valid with fields.RegisterKeyType and
+// fields.RegisterSignatureType before nodes using them can be unmarshaled.
+//
+// A SignatureRegistry is safe for concurrent use by multiple goroutines.
+type SignatureRegistry struct {
+	mutex           sync.RWMutex
+	verifiers       map[signatureScheme]Verifier
+	signerFactories map[fields.KeyType]SignerFactory
+}
+
+// NewSignatureRegistry creates an empty SignatureRegistry.
+func NewSignatureRegistry() *SignatureRegistry {
+	return &SignatureRegistry{
+		verifiers:       make(map[signatureScheme]Verifier),
+		signerFactories: make(map[fields.KeyType]SignerFactory),
+	}
+}
+
+// DefaultSignatureRegistry is used by ValidateSignature. It supports OpenPGP
+// and Ed25519 keys and signatures.
+var DefaultSignatureRegistry = NewSignatureRegistry()
+
+func init() {
+	DefaultSignatureRegistry.RegisterVerifier(fields.KeyTypeOpenPGP, fields.SignatureTypeOpenPGP, VerifierFunc(verifyOpenPGP))
+	DefaultSignatureRegistry.RegisterSignerFactory(fields.KeyTypeOpenPGP, newOpenPGPSigner)
+	DefaultSignatureRegistry.RegisterVerifier(fields.KeyTypeEd25519, fields.SignatureTypeEd25519, VerifierFunc(verifyEd25519))
+	DefaultSignatureRegistry.RegisterSignerFactory(fields.KeyTypeEd25519, newEd25519Signer)
+}
+
+// RegisterVerifier sets the Verifier used to check signatures of the given type
+// made by keys of the given type, replacing any previously registered Verifier.
+func (r *SignatureRegistry) RegisterVerifier(keyType fields.KeyType, signatureType fields.SignatureType, verifier Verifier) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.verifiers[signatureScheme{keyType, signatureType}] = verifier
+}
+
+// Verifier returns the Verifier registered for the given key and signature types, if any.
+func (r *SignatureRegistry) Verifier(keyType fields.KeyType, signatureType fields.SignatureType) (Verifier, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	verifier, ok := r.verifiers[signatureScheme{keyType, signatureType}]
+	return verifier, ok
+}
+
+// RegisterSignerFactory sets the SignerFactory used to create Signers for private
+// keys of the given type, replacing any previously registered SignerFactory.
+func (r *SignatureRegistry) RegisterSignerFactory(keyType fields.KeyType, factory SignerFactory) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.signerFactories[keyType] = factory
+}
+
+// NewSigner creates a Signer from the serialized private key of the given type.
+func (r *SignatureRegistry) NewSigner(keyType fields.KeyType, privateKey []byte) (Signer, error) {
+	r.mutex.RLock()
+	factory, ok := r.signerFactories[keyType]
+	r.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("No signer registered for key type %d", keyType)
+	}
+	return factory(privateKey)
+}
+
 // ValidateSignature returns whether the signature contained in this SignatureValidator is a valid
-// signature for the given Identity. When validating an Identity node, you should
-// pass the same Identity as the second parameter.
-func ValidateSignature(v SignatureValidator, identity *Identity) (bool, error) {
+// signature for the given Identity using the verifiers within the registry. When validating an
+// Identity node, you should pass the same Identity as the second parameter.
+func (r *SignatureRegistry) ValidateSignature(v SignatureValidator, identity *Identity) (bool, error) {
 	sigIdHash := v.SignatureIdentityHash()
 	if sigIdHash.Equals(fields.NullHash()) {
 		if !v.IsIdentity() {
@@ -30,24 +121,95 @@ func ValidateSignature(v SignatureValidator, identity *Identity) (bool, error) {
 	} else if !sigIdHash.Equals(identity.ID()) {
 		return false, fmt.Errorf("This node was signed by a different identity")
 	}
-	// get the key used to sign this node
-	pubkeyBuf := bytes.NewBuffer([]byte(identity.PublicKey.Blob))
-	pubkeyEntity, err := openpgp.ReadEntity(packet.NewReader(pubkeyBuf))
+	return r.validateSignatureWithKey(v, &identity.PublicKey)
+}
+
+// ValidateSignatureWithHistory returns whether the signature contained in this
+// SignatureValidator is a valid signature by the key that the history's
+// Identity used for it (see KeyHistory.KeyFor). Nodes signed by a revoked key
+// are rejected with an error.
+func (r *SignatureRegistry) ValidateSignatureWithHistory(v SignatureValidator, history *KeyHistory) (bool, error) {
+	if v.IsIdentity() {
+		return r.ValidateSignature(v, history.Identity)
+	}
+	if !v.SignatureIdentityHash().Equals(history.Identity.ID()) {
+		return false, fmt.Errorf("This node was signed by a different identity")
+	}
+	key, err := history.KeyFor(v)
 	if err != nil {
 		return false, err
 	}
+	return r.validateSignatureWithKey(v, key)
+}
 
+// validateSignatureWithKey checks the signature of v against the given key.
+func (r *SignatureRegistry) validateSignatureWithKey(v SignatureValidator, key *fields.QualifiedKey) (bool, error) {
+	signature := v.GetSignature()
+	verifier, ok := r.Verifier(key.Descriptor.Type, signature.Descriptor.Type)
+	if !ok {
+		return false, fmt.Errorf("No verifier registered for key type %d and signature type %d", key.Descriptor.Type, signature.Descriptor.Type)
+	}
 	signedContent, err := v.MarshalSignedData()
 	if err != nil {
 		return false, err
 	}
-	signedContentBuf := bytes.NewBuffer(signedContent)
+	if err := verifier.Verify(key, signedContent, signature); err != nil {
+		return false, err
+	}
+	return true, nil
+}
 
-	signatureBuf := bytes.NewBuffer([]byte(v.GetSignature().Blob))
+// ValidateSignature returns whether the signature contained in this SignatureValidator is a valid
+// signature for the given Identity. When validating an Identity node, you should
+// pass the same Identity as the second parameter. Signatures are checked with the
+// verifiers in the DefaultSignatureRegistry.
+func ValidateSignature(v SignatureValidator, identity *Identity) (bool, error) {
+	return DefaultSignatureRegistry.ValidateSignature(v, identity)
+}
+
+// ValidateSignatureWithHistory returns whether the signature contained in this
+// SignatureValidator is a valid signature by the key that the history's
+// Identity used for it. Signatures are checked with the verifiers in the
+// DefaultSignatureRegistry.
+func ValidateSignatureWithHistory(v SignatureValidator, history *KeyHistory) (bool, error) {
+	return DefaultSignatureRegistry.ValidateSignatureWithHistory(v, history)
+}
+
+// verifyOpenPGP checks a detached OpenPGP signature using a binary OpenPGP public key.
+func verifyOpenPGP(key *fields.QualifiedKey, data []byte, signature *fields.QualifiedSignature) error {
+	pubkeyEntity, err := openpgp.ReadEntity(packet.NewReader(bytes.NewBuffer([]byte(key.Blob))))
+	if err != nil {
+		return err
+	}
 	keyring := openpgp.EntityList([]*openpgp.Entity{pubkeyEntity})
-	_, err = openpgp.CheckDetachedSignature(keyring, signedContentBuf, signatureBuf)
+	_, err = openpgp.CheckDetachedSignature(keyring, bytes.NewBuffer(data), bytes.NewBuffer([]byte(signature.Blob)))
+	return err
+}
+
+// newOpenPGPSigner creates a NativeSigner from a binary OpenPGP private key.
+func newOpenPGPSigner(privateKey []byte) (Signer, error) {
+	entity, err := openpgp.ReadEntity(packet.NewReader(bytes.NewBuffer(privateKey)))
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	return true, nil
+	if entity.PrivateKey == nil {
+		return nil, fmt.Errorf("OpenPGP key does not contain a private key")
+	}
+	return NewNativeSigner(entity)
+}
+
+// verifyEd25519 checks an Ed25519 signature using a raw Ed25519 public key.
+func verifyEd25519(key *fields.QualifiedKey, data []byte, signature *fields.QualifiedSignature) error {
+	if len(key.Blob) != ed25519.PublicKeySize {
+		return fmt.Errorf("Ed25519 public key must be %d bytes, got %d", ed25519.PublicKeySize, len(key.Blob))
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key.Blob), data, signature.Blob) {
+		return fmt.Errorf("Ed25519 signature is invalid")
+	}
+	return nil
+}
+
+// newEd25519Signer creates an Ed25519Signer from a raw Ed25519 private key.
+func newEd25519Signer(privateKey []byte) (Signer, error) {
+	return NewEd25519Signer(ed25519.PrivateKey(privateKey))
 }
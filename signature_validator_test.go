@@ -0,0 +1,124 @@
+package forest_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	forest "git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+	"golang.org/x/crypto/openpgp"
+)
+
+const (
+	testKeyType       fields.KeyType       = 200
+	testSignatureType fields.SignatureType = 200
+)
+
+func init() {
+	if err := fields.RegisterKeyType(testKeyType, "Test"); err != nil {
+		panic(err)
+	}
+	if err := fields.RegisterSignatureType(testSignatureType, "Test"); err != nil {
+		panic(err)
+	}
+}
+
+// checksumSigner is an insecure signer whose signatures are checksums of its
+// key and the data. It exists only to exercise the SignatureRegistry.
+type checksumSigner []byte
+
+func checksum(key, data []byte) []byte {
+	sum := sha256.Sum256(append(append([]byte{}, key...), data...))
+	return sum[:]
+}
+
+func (c checksumSigner) Sign(data []byte) ([]byte, error) {
+	return checksum(c, data), nil
+}
+
+func (c checksumSigner) PublicKey() ([]byte, error) {
+	return c, nil
+}
+
+func (c checksumSigner) KeyType() fields.KeyType {
+	return testKeyType
+}
+
+func (c checksumSigner) SignatureType() fields.SignatureType {
+	return testSignatureType
+}
+
+func verifyChecksum(key *fields.QualifiedKey, data []byte, signature *fields.QualifiedSignature) error {
+	if !bytes.Equal(checksum(key.Blob, data), signature.Blob) {
+		return fmt.Errorf("checksum mismatch")
+	}
+	return nil
+}
+
+func TestSignatureRegistryCustomVerifier(t *testing.T) {
+	signer := checksumSigner("test key")
+	username := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte("Test Name"))
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	identity, err := forest.NewIdentity(signer, username, metadata)
+	if err != nil {
+		t.Fatal("Failed to create Identity with custom signer", err)
+	}
+	if correct, err := forest.ValidateSignature(identity, identity); err == nil && correct {
+		t.Error("Default registry should not be able to validate unregistered signature types")
+	}
+	registry := forest.NewSignatureRegistry()
+	registry.RegisterVerifier(testKeyType, testSignatureType, forest.VerifierFunc(verifyChecksum))
+	if correct, err := registry.ValidateSignature(identity, identity); err != nil || !correct {
+		t.Error("Registry with custom verifier should validate signature", err)
+	}
+	identity.Name.Blob = fields.Blob([]byte("TEST NAME"))
+	if correct, err := registry.ValidateSignature(identity, identity); err == nil && correct {
+		t.Error("Registry with custom verifier should reject tampered node")
+	}
+}
+
+func TestDefaultSignatureRegistrySigners(t *testing.T) {
+	privkey, err := openpgp.NewEntity("forest-test", "comment", "email@email.io", nil)
+	if err != nil {
+		t.Skip("Failed to create private key", err)
+	}
+	buf := new(bytes.Buffer)
+	if err := privkey.SerializePrivate(buf, nil); err != nil {
+		t.Skip("Failed to serialize private key", err)
+	}
+	_, edkey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Skip("Failed to generate Ed25519 key", err)
+	}
+	keys := map[fields.KeyType][]byte{
+		fields.KeyTypeOpenPGP: buf.Bytes(),
+		fields.KeyTypeEd25519: edkey,
+	}
+	for keyType, key := range keys {
+		signer, err := forest.DefaultSignatureRegistry.NewSigner(keyType, key)
+		if err != nil {
+			t.Errorf("Failed to create signer for key type %d: %v", keyType, err)
+			continue
+		}
+		username := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte("Test Name"))
+		metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+		identity, err := forest.NewIdentity(signer, username, metadata)
+		if err != nil {
+			t.Errorf("Failed to create Identity with key type %d: %v", keyType, err)
+			continue
+		}
+		if identity.PublicKey.Descriptor.Type != keyType {
+			t.Errorf("Expected identity with key type %d, got %d", keyType, identity.PublicKey.Descriptor.Type)
+		}
+		if correct, err := forest.ValidateSignature(identity, identity); err != nil || !correct {
+			t.Errorf("Signature validation failed for key type %d: %v", keyType, err)
+		}
+	}
+	if _, err := forest.DefaultSignatureRegistry.NewSigner(testKeyType, []byte("key")); err == nil {
+		t.Error("Default registry should not create signers for unregistered key types")
+	}
+}
@@ -1,6 +1,8 @@
 package forest_test
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/base64"
 	"io/ioutil"
 	"os"
@@ -100,3 +102,57 @@ func TestGPGSignerAsIdentity(t *testing.T) {
 		t.Error("Signature validation failed on unmodified node", err)
 	}
 }
+
+func MakeEd25519SignerOrSkip(t *testing.T) forest.Signer {
+	_, privkey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Skip("Failed to generate Ed25519 key", err)
+	}
+	signer, err := forest.NewEd25519Signer(privkey)
+	if err != nil {
+		t.Skip("Failed to construct Ed25519 signer", err)
+	}
+	return signer
+}
+
+func TestEd25519SignerRejectsShortKey(t *testing.T) {
+	if _, err := forest.NewEd25519Signer(ed25519.PrivateKey([]byte{1, 2, 3})); err == nil {
+		t.Error("NewEd25519Signer should reject keys of the wrong length")
+	}
+}
+
+func TestEd25519SignerNodes(t *testing.T) {
+	signer := MakeEd25519SignerOrSkip(t)
+	username := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte("Test Name"))
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	identity, err := forest.NewIdentity(signer, username, metadata)
+	if err != nil {
+		t.Fatal("Failed to create Identity with valid parameters", err)
+	}
+	if identity.PublicKey.Descriptor.Type != fields.KeyTypeEd25519 {
+		t.Errorf("Identity should have an Ed25519 key, got type %d", identity.PublicKey.Descriptor.Type)
+	}
+	if identity.Signature.Descriptor.Type != fields.SignatureTypeEd25519 {
+		t.Errorf("Identity should have an Ed25519 signature, got type %d", identity.Signature.Descriptor.Type)
+	}
+	if correct, err := forest.ValidateSignature(identity, identity); err != nil || !correct {
+		t.Error("Signature validation failed on unmodified identity", err)
+	}
+	community, err := forest.As(identity, signer).NewCommunity(username, metadata)
+	if err != nil {
+		t.Fatal("Failed to create Community with valid parameters", err)
+	}
+	reply, err := forest.As(identity, signer).NewReply(community, username, metadata)
+	if err != nil {
+		t.Fatal("Failed to create Reply with valid parameters", err)
+	}
+	validateReply(t, identity, reply)
+	reply.Content.Blob = fields.Blob([]byte("TEST NAME"))
+	failToValidateReply(t, identity, reply)
+
+	// signatures of the wrong type must be rejected
+	community.Signature.Descriptor.Type = fields.SignatureTypeOpenPGP
+	if correct, err := forest.ValidateSignature(community, identity); err == nil && correct {
+		t.Error("Signature validation should fail when the signature type does not match the key type")
+	}
+}
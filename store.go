@@ -1,6 +1,10 @@
 package forest
 
 import (
+	"errors"
+	"fmt"
+	"sync"
+
 	"git.sr.ht/~whereswaldon/forest-go/fields"
 )
 
@@ -11,21 +15,104 @@ type Store interface {
 	Add(Node) error
 }
 
+// ChildStore is a Store that can efficiently look up the children of a node.
+type ChildStore interface {
+	Store
+	// Children returns the IDs of all nodes within the store whose parent
+	// is the node with the given ID.
+	Children(*fields.QualifiedHash) ([]*fields.QualifiedHash, error)
+}
+
+// QueryStore is a Store that maintains secondary indices over the fields of
+// its nodes so that they can be searched without scanning the entire store.
+type QueryStore interface {
+	Store
+	// Query returns a single page of the nodes matching the query.
+	Query(*Query) (*QueryPage, error)
+}
+
+// ErrStopIteration can be returned by the function passed to Iterate in order
+// to end the iteration early. Iterate will then return nil.
+var ErrStopIteration = errors.New("stop iteration")
+
+// IterableStore is a Store that can enumerate every node that it contains.
+type IterableStore interface {
+	Store
+	// Iterate invokes fn once for each node within the store in no particular
+	// order. If fn returns ErrStopIteration the iteration ends and Iterate
+	// returns nil. If fn returns any other error, the iteration ends and that
+	// error is returned.
+	Iterate(fn func(Node) error) error
+}
+
+// MemoryStore is a Store that holds nodes in memory. It is safe for concurrent
+// use by multiple goroutines, though the Items map must not be accessed
+// directly while the MemoryStore is in use. The zero value is an empty store
+// ready to use, and a MemoryStore may also be created with its Items already
+// populated.
 type MemoryStore struct {
 	Items map[string]Node
+	index *nodeIndex
+	mutex sync.RWMutex
 }
 
 func NewMemoryStore() *MemoryStore {
-	return &MemoryStore{make(map[string]Node)}
+	return &MemoryStore{
+		Items: make(map[string]Node),
+		index: newNodeIndex(),
+	}
+}
+
+// ensureIndex creates the index of a MemoryStore that was not created by
+// NewMemoryStore, including any nodes already within its Items.
+func (m *MemoryStore) ensureIndex() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.ensureIndexLocked()
+}
+
+// ensureIndexLocked is ensureIndex for callers that already hold the mutex
+// for writing.
+func (m *MemoryStore) ensureIndexLocked() error {
+	if m.Items == nil {
+		m.Items = make(map[string]Node)
+	}
+	if m.index != nil {
+		return nil
+	}
+	index := newNodeIndex()
+	for _, node := range m.Items {
+		if err := index.add(node); err != nil {
+			return err
+		}
+	}
+	m.index = index
+	return nil
 }
 
 func (m *MemoryStore) Size() (int, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 	return len(m.Items), nil
 }
 
 func (m *MemoryStore) CopyInto(other Store) error {
+	return m.Iterate(other.Add)
+}
+
+// Iterate invokes fn once for each node within the store. It iterates over a
+// snapshot of the store's contents, so fn may safely modify the store.
+func (m *MemoryStore) Iterate(fn func(Node) error) error {
+	m.mutex.RLock()
+	nodes := make([]Node, 0, len(m.Items))
 	for _, node := range m.Items {
-		if err := other.Add(node); err != nil {
+		nodes = append(nodes, node)
+	}
+	m.mutex.RUnlock()
+	for _, node := range nodes {
+		if err := fn(node); err == ErrStopIteration {
+			return nil
+		} else if err != nil {
 			return err
 		}
 	}
@@ -41,6 +128,13 @@ func (m *MemoryStore) Get(id *fields.QualifiedHash) (Node, bool, error) {
 }
 
 func (m *MemoryStore) GetID(id string) (Node, bool, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.getID(id)
+}
+
+// getID looks up a node without acquiring the mutex.
+func (m *MemoryStore) getID(id string) (Node, bool, error) {
 	item, has := m.Items[id]
 	return item, has, nil
 }
@@ -54,21 +148,53 @@ func (m *MemoryStore) Add(node Node) error {
 }
 
 func (m *MemoryStore) AddID(id string, node Node) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.ensureIndexLocked(); err != nil {
+		return err
+	}
 	// safe to ignore error because we know it can't happen
-	if _, has, _ := m.GetID(id); has {
+	if _, has, _ := m.getID(id); has {
 		return nil
 	}
+	if err := m.index.add(node); err != nil {
+		return err
+	}
 	m.Items[id] = node
 	return nil
 }
 
+// Children returns the IDs of all known children of the node with the given ID.
+func (m *MemoryStore) Children(id *fields.QualifiedHash) ([]*fields.QualifiedHash, error) {
+	if err := m.ensureIndex(); err != nil {
+		return nil, err
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.index.Children(id)
+}
+
+// Query returns a single page of the nodes matching the query.
+func (m *MemoryStore) Query(q *Query) (*QueryPage, error) {
+	if err := m.ensureIndex(); err != nil {
+		return nil, err
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.index.queryStore(q, m.getID)
+}
+
 // CacheStore combines two other stores into one logical store. It is
 // useful when store implementations have different performance
 // characteristics and one is dramatically faster than the other. Once
 // a CacheStore is created, the individual stores within it should not
 // be directly modified.
+//
+// A CacheStore is safe for concurrent use by multiple goroutines even if
+// its individual stores are not, because it serializes all access to them.
 type CacheStore struct {
 	Cache, Back Store
+	mutex       sync.Mutex
 }
 
 // NewCacheStore creates a single logical store from the given two stores.
@@ -82,12 +208,14 @@ func NewCacheStore(cache, back Store) (*CacheStore, error) {
 	if err := cache.CopyInto(back); err != nil {
 		return nil, err
 	}
-	return &CacheStore{cache, back}, nil
+	return &CacheStore{Cache: cache, Back: back}, nil
 }
 
 // Size returns the effective size of this CacheStore, which is the size of the
 // Back Store.
 func (m *CacheStore) Size() (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	return m.Back.Size()
 }
 
@@ -95,6 +223,8 @@ func (m *CacheStore) Size() (int, error) {
 // If the cache is missed by the backing store is hit, the node will automatically be
 // added to the cache.
 func (m *CacheStore) Get(id *fields.QualifiedHash) (Node, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	if node, has, err := m.Cache.Get(id); err != nil {
 		return nil, false, err
 	} else if has {
@@ -111,12 +241,18 @@ func (m *CacheStore) Get(id *fields.QualifiedHash) (Node, bool, error) {
 	return nil, false, nil
 }
 
+// CopyInto copies every node from the Back Store into other. The CacheStore
+// is locked until the copy is complete, so other must not be the CacheStore.
 func (m *CacheStore) CopyInto(other Store) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	return m.Back.CopyInto(other)
 }
 
 // Add inserts the given node into both stores of the CacheStore
 func (m *CacheStore) Add(node Node) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	if err := m.Back.Add(node); err != nil {
 		return err
 	}
@@ -125,3 +261,40 @@ func (m *CacheStore) Add(node Node) error {
 	}
 	return nil
 }
+
+// Children returns the IDs of all known children of the node with the given
+// ID. The Back Store must be a ChildStore.
+func (m *CacheStore) Children(id *fields.QualifiedHash) ([]*fields.QualifiedHash, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	back, ok := m.Back.(ChildStore)
+	if !ok {
+		return nil, fmt.Errorf("Back store %T does not support Children", m.Back)
+	}
+	return back.Children(id)
+}
+
+// Query returns a single page of the nodes matching the query. The Back Store
+// must be a QueryStore.
+func (m *CacheStore) Query(q *Query) (*QueryPage, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	back, ok := m.Back.(QueryStore)
+	if !ok {
+		return nil, fmt.Errorf("Back store %T does not support Query", m.Back)
+	}
+	return back.Query(q)
+}
+
+// Iterate invokes fn once for each node within the CacheStore. The Back Store
+// must be an IterableStore. The CacheStore is locked for the duration of the
+// iteration, so fn must not use the CacheStore.
+func (m *CacheStore) Iterate(fn func(Node) error) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	back, ok := m.Back.(IterableStore)
+	if !ok {
+		return fmt.Errorf("Back store %T does not support Iterate", m.Back)
+	}
+	return back.Iterate(fn)
+}
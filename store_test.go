@@ -1,9 +1,15 @@
 package forest_test
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 
 	"git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
 )
 
 func TestMemoryStore(t *testing.T) {
@@ -11,6 +17,26 @@ func TestMemoryStore(t *testing.T) {
 	testStandardStoreInterface(t, s, "MemoryStore")
 }
 
+func TestMemoryStoreLiteral(t *testing.T) {
+	testStandardStoreInterface(t, &forest.MemoryStore{Items: map[string]forest.Node{}}, "literal MemoryStore")
+	testStandardStoreInterface(t, new(forest.MemoryStore), "zero MemoryStore")
+	testChildStore(t, new(forest.MemoryStore), "zero MemoryStore")
+	testQueryStore(t, new(forest.MemoryStore), "zero MemoryStore")
+
+	// nodes placed directly within Items are indexed on first use
+	identity, _, com, rep := MakeReplyOrSkip(t)
+	items := map[string]forest.Node{}
+	for _, node := range []forest.Node{identity, com, rep} {
+		id, err := node.ID().MarshalString()
+		if err != nil {
+			t.Skip("Failed to marshal node ID", err)
+		}
+		items[id] = node
+	}
+	s := &forest.MemoryStore{Items: items}
+	checkQuery(t, s, &forest.Query{CommunityID: com.ID()}, []forest.Node{rep}, "prepopulated MemoryStore")
+}
+
 func testStandardStoreInterface(t *testing.T, s forest.Store, storeImplName string) {
 	if size, err := s.Size(); size != 0 {
 		t.Errorf("Expected new %s to have size 0, had %d", storeImplName, size)
@@ -121,3 +147,397 @@ func TestCacheStoreUpPropagation(t *testing.T) {
 		}
 	}
 }
+
+func makeOrchardStoreOrSkip(t *testing.T) (*forest.OrchardStore, func()) {
+	dir, err := ioutil.TempDir("", "forest-orchard")
+	if err != nil {
+		t.Skip("Failed to create temporary directory", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	s, err := forest.NewOrchardStore(dir)
+	if err != nil {
+		cleanup()
+		t.Fatalf("Failed to create OrchardStore in %s: %v", dir, err)
+	}
+	return s, cleanup
+}
+
+func TestOrchardStore(t *testing.T) {
+	s, cleanup := makeOrchardStoreOrSkip(t)
+	defer cleanup()
+	testStandardStoreInterface(t, s, "OrchardStore")
+}
+
+func TestOrchardStorePersists(t *testing.T) {
+	s, cleanup := makeOrchardStoreOrSkip(t)
+	defer cleanup()
+	id, _, com, rep := MakeReplyOrSkip(t)
+	nodes := []forest.Node{id, com, rep}
+	for _, node := range nodes {
+		if err := s.Add(node); err != nil {
+			t.Errorf("Failed adding %v to OrchardStore: %v", node.ID(), err)
+		}
+	}
+	// simulate a crash that left a partially-written node behind
+	if err := ioutil.WriteFile(filepath.Join(s.Dir, ".tmp-partial"), []byte{1, 2, 3}, 0600); err != nil {
+		t.Skip("Failed to write partial file", err)
+	}
+	reopened, err := forest.NewOrchardStore(s.Dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen OrchardStore: %v", err)
+	}
+	if size, err := reopened.Size(); err != nil {
+		t.Errorf("Reopened OrchardStore Size() should not err, got %v", err)
+	} else if size != len(nodes) {
+		t.Errorf("Reopened OrchardStore should have size %d, got %d", len(nodes), size)
+	}
+	for _, node := range nodes {
+		if n2, has, err := reopened.Get(node.ID()); err != nil {
+			t.Errorf("Unexpected error getting node from reopened OrchardStore: %v", err)
+		} else if !has {
+			t.Errorf("Expected reopened OrchardStore to contain %v", node.ID())
+		} else if !n2.Equals(node) {
+			t.Errorf("Expected reopened OrchardStore to contain the same value for ID %v", node.ID())
+		}
+	}
+}
+
+func TestOrchardStoreGetIDStaysWithinDir(t *testing.T) {
+	outer, cleanup := makeOrchardStoreOrSkip(t)
+	defer cleanup()
+	identity, _ := MakeIdentityOrSkip(t)
+	if err := outer.Add(identity); err != nil {
+		t.Skip("Failed to add identity", err)
+	}
+	id, err := identity.ID().MarshalString()
+	if err != nil {
+		t.Skip("Failed to marshal ID", err)
+	}
+	inner, err := forest.NewOrchardStore(filepath.Join(outer.Dir, "inner"))
+	if err != nil {
+		t.Fatalf("Failed to create OrchardStore: %v", err)
+	}
+	if node, has, err := inner.GetID(filepath.Join("..", id)); err == nil || has || node != nil {
+		t.Errorf("GetID should not read nodes outside of the store's directory")
+	}
+	if _, has, err := outer.GetID(id); err != nil || !has {
+		t.Errorf("GetID should find a node by its ID, got %v", err)
+	}
+}
+
+func TestCacheStoreOrchardBack(t *testing.T) {
+	back, cleanup := makeOrchardStoreOrSkip(t)
+	defer cleanup()
+	c, err := forest.NewCacheStore(forest.NewMemoryStore(), back)
+	if err != nil {
+		t.Errorf("Unexpected error constructing CacheStore: %v", err)
+	}
+	testStandardStoreInterface(t, c, "CacheStore with OrchardStore back")
+}
+
+// testChildStore populates the store with a small tree and checks that the
+// children of each node are reported correctly. It returns the expected
+// children of each node added.
+func testChildStore(t *testing.T, s forest.ChildStore, storeImplName string) map[forest.Node][]*fields.QualifiedHash {
+	identity, signer, com, rep := MakeReplyOrSkip(t)
+	content := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte("child"))
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	rep2, err := forest.As(identity, signer).NewReply(rep, content, metadata)
+	if err != nil {
+		t.Skip("Failed to create reply to reply", err)
+	}
+	for _, node := range []forest.Node{identity, com, rep, rep2} {
+		if err := s.Add(node); err != nil {
+			t.Errorf("%s Add() should not err on Add(): %s", storeImplName, err)
+		}
+	}
+	expected := map[forest.Node][]*fields.QualifiedHash{
+		identity: nil,
+		com:      {rep.ID()},
+		rep:      {rep2.ID()},
+		rep2:     nil,
+	}
+	checkChildren(t, s, expected, storeImplName)
+	return expected
+}
+
+func checkChildren(t *testing.T, s forest.ChildStore, expected map[forest.Node][]*fields.QualifiedHash, storeImplName string) {
+	for parent, children := range expected {
+		found, err := s.Children(parent.ID())
+		if err != nil {
+			t.Errorf("%s Children() should not err, got %s", storeImplName, err)
+		} else if len(found) != len(children) {
+			t.Errorf("%s Children() should return %d children for %v, got %d", storeImplName, len(children), parent.ID(), len(found))
+		} else {
+			for i := range children {
+				if !children[i].Equals(found[i]) {
+					t.Errorf("%s Children() returned %v, expected %v", storeImplName, found[i], children[i])
+				}
+			}
+		}
+	}
+}
+
+func TestMemoryStoreChildren(t *testing.T) {
+	testChildStore(t, forest.NewMemoryStore(), "MemoryStore")
+}
+
+func TestCacheStoreChildren(t *testing.T) {
+	c, err := forest.NewCacheStore(forest.NewMemoryStore(), forest.NewMemoryStore())
+	if err != nil {
+		t.Errorf("Unexpected error constructing CacheStore: %v", err)
+	}
+	testChildStore(t, c, "CacheStore")
+}
+
+func TestOrchardStoreChildren(t *testing.T) {
+	s, cleanup := makeOrchardStoreOrSkip(t)
+	defer cleanup()
+	expected := testChildStore(t, s, "OrchardStore")
+	reopened, err := forest.NewOrchardStore(s.Dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen OrchardStore: %v", err)
+	}
+	checkChildren(t, reopened, expected, "reopened OrchardStore")
+}
+
+func newReplyOrSkip(t *testing.T, builder *forest.Builder, parent interface{}, text string) *forest.Reply {
+	content := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte(text))
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	reply, err := builder.NewReply(parent, content, metadata)
+	if err != nil {
+		t.Skip("Failed to create reply", err)
+	}
+	return reply
+}
+
+func checkQuery(t *testing.T, s forest.QueryStore, q *forest.Query, expected []forest.Node, storeImplName string) {
+	page, err := s.Query(q)
+	if err != nil {
+		t.Errorf("%s Query() should not err, got %v", storeImplName, err)
+		return
+	}
+	if len(page.Nodes) != len(expected) {
+		t.Errorf("%s Query() should return %d nodes, got %d", storeImplName, len(expected), len(page.Nodes))
+		return
+	}
+	for _, node := range expected {
+		found := false
+		for _, result := range page.Nodes {
+			if node.Equals(result) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("%s Query() results should contain %v", storeImplName, node.ID())
+		}
+	}
+}
+
+func testQueryStore(t *testing.T, s forest.QueryStore, storeImplName string) {
+	identity, signer, com, rep1 := MakeReplyOrSkip(t)
+	other, otherSigner := MakeIdentityOrSkip(t)
+	builder := forest.As(identity, signer)
+	rep2 := newReplyOrSkip(t, builder, rep1, "depth two")
+	rep3 := newReplyOrSkip(t, builder, rep2, "depth three")
+	rep4 := newReplyOrSkip(t, builder, rep1, "also depth two")
+	rep5 := newReplyOrSkip(t, forest.As(other, otherSigner), rep1, "other author")
+	for _, node := range []forest.Node{identity, other, com, rep1, rep2, rep3, rep4, rep5} {
+		if err := s.Add(node); err != nil {
+			t.Errorf("%s Add() should not err on Add(): %s", storeImplName, err)
+		}
+	}
+	checkQuery(t, s, &forest.Query{Author: identity.ID()}, []forest.Node{com, rep1, rep2, rep3, rep4}, storeImplName)
+	checkQuery(t, s, &forest.Query{CommunityID: com.ID()}, []forest.Node{rep1, rep2, rep3, rep4, rep5}, storeImplName)
+	checkQuery(t, s, &forest.Query{ConversationID: rep1.ID()}, []forest.Node{rep2, rep3, rep4, rep5}, storeImplName)
+	checkQuery(t, s, &forest.Query{ConversationID: rep1.ID(), Author: other.ID()}, []forest.Node{rep5}, storeImplName)
+	if _, err := s.Query(&forest.Query{}); err == nil {
+		t.Errorf("%s Query() should err without any filters", storeImplName)
+	}
+
+	// page through the community two nodes at a time
+	var (
+		results []forest.Node
+		q       = &forest.Query{CommunityID: com.ID(), Limit: 2}
+	)
+	for pages := 0; pages < 10; pages++ {
+		page, err := s.Query(q)
+		if err != nil {
+			t.Fatalf("%s Query() should not err while paging, got %v", storeImplName, err)
+		}
+		if len(page.Nodes) > q.Limit {
+			t.Errorf("%s Query() returned %d nodes, limit was %d", storeImplName, len(page.Nodes), q.Limit)
+		}
+		results = append(results, page.Nodes...)
+		if page.Next == "" {
+			break
+		}
+		q.Cursor = page.Next
+	}
+	if len(results) != 5 {
+		t.Fatalf("%s Query() pages should contain 5 nodes in total, got %d", storeImplName, len(results))
+	}
+	if !results[0].Equals(rep1) || !results[4].Equals(rep3) {
+		t.Errorf("%s Query() results should be ordered by depth", storeImplName)
+	}
+}
+
+func TestMemoryStoreQuery(t *testing.T) {
+	testQueryStore(t, forest.NewMemoryStore(), "MemoryStore")
+}
+
+func TestCacheStoreQuery(t *testing.T) {
+	c, err := forest.NewCacheStore(forest.NewMemoryStore(), forest.NewMemoryStore())
+	if err != nil {
+		t.Errorf("Unexpected error constructing CacheStore: %v", err)
+	}
+	testQueryStore(t, c, "CacheStore")
+}
+
+func TestOrchardStoreQuery(t *testing.T) {
+	s, cleanup := makeOrchardStoreOrSkip(t)
+	defer cleanup()
+	testQueryStore(t, s, "OrchardStore")
+}
+
+func testIterableStore(t *testing.T, s forest.IterableStore, storeImplName string) {
+	id, _, com, rep := MakeReplyOrSkip(t)
+	nodes := []forest.Node{id, com, rep}
+	for _, node := range nodes {
+		if err := s.Add(node); err != nil {
+			t.Errorf("%s Add() should not err on Add(): %s", storeImplName, err)
+		}
+	}
+	visited := 0
+	if err := s.Iterate(func(node forest.Node) error {
+		visited++
+		for _, expected := range nodes {
+			if expected.Equals(node) {
+				return nil
+			}
+		}
+		t.Errorf("%s Iterate() visited unexpected node %v", storeImplName, node.ID())
+		return nil
+	}); err != nil {
+		t.Errorf("%s Iterate() should not err, got %v", storeImplName, err)
+	}
+	if visited != len(nodes) {
+		t.Errorf("%s Iterate() should visit %d nodes, visited %d", storeImplName, len(nodes), visited)
+	}
+
+	visited = 0
+	if err := s.Iterate(func(node forest.Node) error {
+		visited++
+		return forest.ErrStopIteration
+	}); err != nil {
+		t.Errorf("%s Iterate() should not err when stopped early, got %v", storeImplName, err)
+	} else if visited != 1 {
+		t.Errorf("%s Iterate() should visit 1 node when stopped early, visited %d", storeImplName, visited)
+	}
+
+	expectedErr := fmt.Errorf("test error")
+	if err := s.Iterate(func(node forest.Node) error {
+		return expectedErr
+	}); err != expectedErr {
+		t.Errorf("%s Iterate() should return the error from its callback, got %v", storeImplName, err)
+	}
+}
+
+func TestMemoryStoreIterate(t *testing.T) {
+	testIterableStore(t, forest.NewMemoryStore(), "MemoryStore")
+}
+
+func TestCacheStoreIterate(t *testing.T) {
+	c, err := forest.NewCacheStore(forest.NewMemoryStore(), forest.NewMemoryStore())
+	if err != nil {
+		t.Errorf("Unexpected error constructing CacheStore: %v", err)
+	}
+	testIterableStore(t, c, "CacheStore")
+}
+
+func TestOrchardStoreIterate(t *testing.T) {
+	s, cleanup := makeOrchardStoreOrSkip(t)
+	defer cleanup()
+	testIterableStore(t, s, "OrchardStore")
+}
+
+// testConcurrentStore exercises the store from many goroutines at once. It is
+// most useful when run with the race detector enabled.
+func testConcurrentStore(t *testing.T, s forest.Store, storeImplName string) {
+	identity, signer, com, rep := MakeReplyOrSkip(t)
+	nodes := []forest.Node{identity, com, rep}
+	builder := forest.As(identity, signer)
+	for i := 0; i < 8; i++ {
+		nodes = append(nodes, newReplyOrSkip(t, builder, rep, fmt.Sprintf("reply %d", i)))
+	}
+	const workers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*len(nodes)*3)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(offset int) {
+			defer wg.Done()
+			for i := range nodes {
+				node := nodes[(i+offset)%len(nodes)]
+				if err := s.Add(node); err != nil {
+					errs <- err
+				}
+				if _, _, err := s.Get(node.ID()); err != nil {
+					errs <- err
+				}
+				if _, err := s.Size(); err != nil {
+					errs <- err
+				}
+			}
+			if err := s.CopyInto(forest.NewMemoryStore()); err != nil {
+				errs <- err
+			}
+			if children, ok := s.(forest.ChildStore); ok {
+				if _, err := children.Children(rep.ID()); err != nil {
+					errs <- err
+				}
+			}
+			if queries, ok := s.(forest.QueryStore); ok {
+				if _, err := queries.Query(&forest.Query{Author: identity.ID()}); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("%s should not err during concurrent use, got %v", storeImplName, err)
+	}
+	if size, err := s.Size(); err != nil {
+		t.Errorf("%s Size() should not err, got %v", storeImplName, err)
+	} else if size != len(nodes) {
+		t.Errorf("%s should contain %d nodes after concurrent Add()s, got %d", storeImplName, len(nodes), size)
+	}
+	if children, ok := s.(forest.ChildStore); ok {
+		if found, err := children.Children(rep.ID()); err != nil {
+			t.Errorf("%s Children() should not err, got %v", storeImplName, err)
+		} else if len(found) != len(nodes)-3 {
+			t.Errorf("%s should index each child once after concurrent Add()s, got %d children", storeImplName, len(found))
+		}
+	}
+}
+
+func TestMemoryStoreConcurrent(t *testing.T) {
+	testConcurrentStore(t, forest.NewMemoryStore(), "MemoryStore")
+}
+
+func TestCacheStoreConcurrent(t *testing.T) {
+	c, err := forest.NewCacheStore(forest.NewMemoryStore(), forest.NewMemoryStore())
+	if err != nil {
+		t.Errorf("Unexpected error constructing CacheStore: %v", err)
+	}
+	testConcurrentStore(t, c, "CacheStore")
+}
+
+func TestOrchardStoreConcurrent(t *testing.T) {
+	s, cleanup := makeOrchardStoreOrSkip(t)
+	defer cleanup()
+	testConcurrentStore(t, s, "OrchardStore")
+}
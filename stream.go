@@ -0,0 +1,155 @@
+package forest
+
+import (
+	"errors"
+	"io"
+
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// decoderReadSize is the number of bytes that a Decoder requests from its
+// reader whenever its buffer does not contain a complete node.
+const decoderReadSize = 4096
+
+// Decoder reads consecutive nodes in their binary form from a stream.
+// The binary form of a node has no framing, so the length of each node is
+// determined from the descriptors that precede each of its variable-length
+// fields. A Decoder may read beyond the end of the last node that it returns.
+type Decoder struct {
+	r   io.Reader
+	buf []byte
+	err error
+}
+
+// NewDecoder creates a Decoder that reads nodes from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Decode reads the next node from the stream. It returns io.EOF when the
+// stream ends cleanly between nodes and io.ErrUnexpectedEOF when the stream
+// ends partway through a node. Once Decode returns an error, the stream
+// cannot be resynchronized and every later call will fail.
+func (d *Decoder) Decode() (Node, error) {
+	for {
+		node, err := d.next()
+		if err == nil {
+			return node, nil
+		} else if !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+		// the buffer holds only part of a node, so read more of it
+		if d.err != nil {
+			if d.err == io.EOF && len(d.buf) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, d.err
+		}
+		d.fill()
+	}
+}
+
+// next attempts to unmarshal a node from the start of the buffer, removing
+// it from the buffer if successful.
+func (d *Decoder) next() (Node, error) {
+	if len(d.buf) == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	length, node, err := binaryNodeLength(d.buf)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		// copy the node's data so that it does not alias the buffer, which is
+		// reused for later reads
+		b := make([]byte, length)
+		copy(b, d.buf)
+		if node, err = UnmarshalBinaryNode(b); err != nil {
+			return nil, err
+		}
+	}
+	d.buf = d.buf[length:]
+	return node, nil
+}
+
+// framedNode is a node whose binary length can be found from the descriptors
+// of its fields, without computing its ID. Every node type declared in this
+// package is a framedNode.
+type framedNode interface {
+	serializer
+	unmarshalBinaryVersion([]byte) error
+}
+
+// binaryNodeLength returns the number of bytes occupied by the node at the
+// start of b, which may be followed by other data. The length of a framedNode
+// is found without unmarshaling it. Other node types are unmarshaled from a
+// copy of b, and the node is also returned if it occupies all of b.
+func binaryNodeLength(b []byte) (int, Node, error) {
+	_, t, err := VersionAndNodeTypeOf(b)
+	if err != nil {
+		return 0, nil, err
+	}
+	empty, err := NewNode(t)
+	if err != nil {
+		return 0, nil, err
+	}
+	framed, ok := empty.(framedNode)
+	if !ok {
+		copied := append([]byte(nil), b...)
+		node, err := UnmarshalBinaryNode(copied)
+		var trailing *TrailingDataError
+		if errors.As(err, &trailing) {
+			return trailing.Consumed, nil, nil
+		} else if err != nil {
+			return 0, nil, err
+		}
+		return len(b), node, nil
+	}
+	if err := framed.unmarshalBinaryVersion(b); err != nil {
+		return 0, nil, err
+	}
+	unused, err := fields.UnmarshalAll(b, fields.AsUnmarshaler(framed.SerializationOrder())...)
+	if err != nil {
+		return 0, nil, err
+	}
+	return len(b) - len(unused), nil, nil
+}
+
+// fill reads more data into the buffer, recording any error from the reader.
+func (d *Decoder) fill() {
+	if len(d.buf) == 0 {
+		d.buf = nil
+	}
+	start := len(d.buf)
+	if cap(d.buf)-start < decoderReadSize {
+		grown := make([]byte, start, 2*start+decoderReadSize)
+		copy(grown, d.buf)
+		d.buf = grown
+	}
+	n, err := d.r.Read(d.buf[start:cap(d.buf)])
+	d.buf = d.buf[:start+n]
+	if err != nil {
+		d.err = err
+	}
+}
+
+// Encoder writes consecutive nodes in their binary form to a stream. The
+// nodes can be read back with a Decoder.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder creates an Encoder that writes nodes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the binary form of the node to the stream.
+func (e *Encoder) Encode(node Node) error {
+	b, err := node.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(b)
+	return err
+}
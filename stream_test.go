@@ -0,0 +1,167 @@
+package forest_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	forest "git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+func encodeNodesOrSkip(t *testing.T, nodes ...forest.Node) []byte {
+	buf := new(bytes.Buffer)
+	encoder := forest.NewEncoder(buf)
+	for _, node := range nodes {
+		if err := encoder.Encode(node); err != nil {
+			t.Skip("Failed to encode node", err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestDecoderRoundTrip(t *testing.T) {
+	identity, _, community, reply := MakeReplyOrSkip(t)
+	nodes := []forest.Node{identity, community, reply, reply}
+	stream := encodeNodesOrSkip(t, nodes...)
+	readers := map[string]io.Reader{
+		"whole":    bytes.NewReader(stream),
+		"one byte": iotest.OneByteReader(bytes.NewReader(stream)),
+		"data EOF": iotest.DataErrReader(bytes.NewReader(stream)),
+	}
+	for name, r := range readers {
+		decoder := forest.NewDecoder(r)
+		for i, expected := range nodes {
+			node, err := decoder.Decode()
+			if err != nil {
+				t.Errorf("%s: failed to decode node %d: %v", name, i, err)
+				break
+			}
+			if !node.Equals(expected) {
+				t.Errorf("%s: decoded node %d does not match encoded node", name, i)
+			}
+		}
+		if _, err := decoder.Decode(); err != io.EOF {
+			t.Errorf("%s: expected io.EOF at the end of the stream, got %v", name, err)
+		}
+	}
+}
+
+func TestDecoderEmptyStream(t *testing.T) {
+	if _, err := forest.NewDecoder(bytes.NewReader(nil)).Decode(); err != io.EOF {
+		t.Errorf("Expected io.EOF from empty stream, got %v", err)
+	}
+}
+
+func TestDecoderTruncatedStream(t *testing.T) {
+	identity, _, community, _ := MakeReplyOrSkip(t)
+	stream := encodeNodesOrSkip(t, identity, community)
+	decoder := forest.NewDecoder(bytes.NewReader(stream[:len(stream)-1]))
+	if node, err := decoder.Decode(); err != nil {
+		t.Errorf("Failed to decode complete node: %v", err)
+	} else if !node.Equals(identity) {
+		t.Errorf("Decoded node does not match encoded node")
+	}
+	if _, err := decoder.Decode(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF from truncated node, got %v", err)
+	}
+}
+
+func TestDecoderInvalidStream(t *testing.T) {
+	identity, _ := MakeIdentityOrSkip(t)
+	stream := encodeNodesOrSkip(t, identity)
+	stream[8] = 0xff // node type
+	if _, err := forest.NewDecoder(bytes.NewReader(stream)).Decode(); err == nil || err == io.EOF || err == io.ErrUnexpectedEOF {
+		t.Errorf("Expected decoding an invalid node type to fail, got %v", err)
+	}
+}
+
+func TestDecoderRegisteredNodeType(t *testing.T) {
+	identity, signer, community, reply := MakeValidReplyOrSkip(t)
+	custom := makeTestNodeOrSkip(t, reply, signer)
+	stream := encodeNodesOrSkip(t, identity, custom, community)
+	decoder := forest.NewDecoder(iotest.OneByteReader(bytes.NewReader(stream)))
+	for i, expected := range []forest.Node{identity, custom, community} {
+		node, err := decoder.Decode()
+		if err != nil {
+			t.Fatalf("Failed to decode node %d: %v", i, err)
+		}
+		if !node.Equals(expected) {
+			t.Errorf("Decoded node %d does not match encoded node", i)
+		}
+	}
+}
+
+// markerNodeType is a custom node type that does not embed any of the node
+// types within the forest package, so a Decoder must rely on its unmarshal
+// function to find where it ends.
+const markerNodeType fields.NodeType = 52
+
+// markerNodeSize is the length of the binary form of a markerNode: its
+// version, its node type and its value.
+const markerNodeSize = 8 + 1 + 4
+
+type markerNode struct {
+	Value uint32
+}
+
+func (m *markerNode) ID() *fields.QualifiedHash       { return fields.NullHash() }
+func (m *markerNode) ParentID() *fields.QualifiedHash { return fields.NullHash() }
+func (m *markerNode) ValidateShallow() error          { return nil }
+func (m *markerNode) ValidateDeep(forest.Store) error { return nil }
+
+func (m *markerNode) Equals(other interface{}) bool {
+	other2, valid := other.(*markerNode)
+	return valid && m.Value == other2.Value
+}
+
+func (m *markerNode) MarshalBinary() ([]byte, error) {
+	b := make([]byte, markerNodeSize)
+	binary.BigEndian.PutUint64(b, uint64(fields.CurrentVersion))
+	b[8] = byte(markerNodeType)
+	binary.BigEndian.PutUint32(b[9:], m.Value)
+	return b, nil
+}
+
+var registerMarkerNodeErr = forest.RegisterNodeType(markerNodeType, "test-marker",
+	func() forest.Node { return new(markerNode) },
+	func(b []byte) (forest.Node, error) {
+		if len(b) < markerNodeSize {
+			return nil, fmt.Errorf("Marker node is too short: %w", io.ErrUnexpectedEOF)
+		} else if len(b) > markerNodeSize {
+			return nil, &forest.TrailingDataError{Consumed: markerNodeSize, Length: len(b)}
+		}
+		return &markerNode{Value: binary.BigEndian.Uint32(b[9:])}, nil
+	})
+
+func TestDecoderUnframedNodeType(t *testing.T) {
+	if registerMarkerNodeErr != nil {
+		t.Fatalf("Failed to register node type: %v", registerMarkerNodeErr)
+	}
+	identity, _, community, _ := MakeReplyOrSkip(t)
+	nodes := []forest.Node{&markerNode{Value: 1}, identity, &markerNode{Value: 2}, &markerNode{Value: 3}, community}
+	stream := encodeNodesOrSkip(t, nodes...)
+	readers := map[string]io.Reader{
+		"whole":    bytes.NewReader(stream),
+		"one byte": iotest.OneByteReader(bytes.NewReader(stream)),
+	}
+	for name, r := range readers {
+		decoder := forest.NewDecoder(r)
+		for i, expected := range nodes {
+			node, err := decoder.Decode()
+			if err != nil {
+				t.Errorf("%s: failed to decode node %d: %v", name, i, err)
+				break
+			}
+			if !node.Equals(expected) {
+				t.Errorf("%s: decoded node %d does not match encoded node", name, i)
+			}
+		}
+		if _, err := decoder.Decode(); err != io.EOF {
+			t.Errorf("%s: expected io.EOF at the end of the stream, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package forest_test
+
+import (
+	"encoding"
+	"encoding/json"
+	"testing"
+
+	forest "git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+type textRoundTripper interface {
+	encoding.TextMarshaler
+	encoding.TextUnmarshaler
+}
+
+func ensureTextRoundTrips(t *testing.T, in textRoundTripper, out textRoundTripper) {
+	text, err := in.MarshalText()
+	if err != nil {
+		t.Errorf("Failed to marshal %T to text: %v", in, err)
+		return
+	}
+	if err := out.UnmarshalText(text); err != nil {
+		t.Errorf("Failed to unmarshal %T from %q: %v", out, text, err)
+		return
+	}
+	text2, err := out.MarshalText()
+	if err != nil {
+		t.Errorf("Failed to marshal %T to text: %v", out, err)
+	} else if string(text) != string(text2) {
+		t.Errorf("Text form of %T changed during round trip, expected %q, got %q", in, text, text2)
+	}
+}
+
+func TestQualifiedTextRoundTrip(t *testing.T) {
+	identity, _, community, reply := MakeReplyOrSkip(t)
+	ensureTextRoundTrips(t, identity.ID(), new(fields.QualifiedHash))
+	ensureTextRoundTrips(t, fields.NullHash(), new(fields.QualifiedHash))
+	ensureTextRoundTrips(t, &community.IDDesc, new(fields.HashDescriptor))
+	ensureTextRoundTrips(t, &identity.PublicKey, new(fields.QualifiedKey))
+	ensureTextRoundTrips(t, &identity.PublicKey.Descriptor, new(fields.KeyDescriptor))
+	ensureTextRoundTrips(t, &reply.Signature, new(fields.QualifiedSignature))
+	ensureTextRoundTrips(t, &reply.Signature.Descriptor, new(fields.SignatureDescriptor))
+	ensureTextRoundTrips(t, &reply.Content, new(fields.QualifiedContent))
+	ensureTextRoundTrips(t, &reply.Content.Descriptor, new(fields.ContentDescriptor))
+	for _, content := range []string{"", "0", "12", "123456789012", "hello__world_B5", "{\"a\":1}"} {
+		for _, contentType := range []fields.ContentType{fields.ContentTypeUTF8String, fields.ContentTypeJSON} {
+			ensureTextRoundTrips(t, QualifiedContentOrSkip(t, contentType, []byte(content)), new(fields.QualifiedContent))
+		}
+	}
+}
+
+func TestParseQualifiedHashForStore(t *testing.T) {
+	identity, _, community, reply := MakeReplyOrSkip(t)
+	store := forest.NewMemoryStore()
+	for _, node := range []forest.Node{identity, community, reply} {
+		if err := store.Add(node); err != nil {
+			t.Skip("Failed to add node", err)
+		}
+		text, err := node.ID().MarshalString()
+		if err != nil {
+			t.Fatalf("Failed to marshal ID: %v", err)
+		}
+		id, err := fields.ParseQualifiedHash(text)
+		if err != nil {
+			t.Errorf("Failed to parse ID %q: %v", text, err)
+			continue
+		}
+		if found, has, err := store.Get(id); err != nil || !has || !found.Equals(node) {
+			t.Errorf("Parsed ID %q should find the original node, err: %v", text, err)
+		}
+	}
+	var decoded struct{ ID *fields.QualifiedHash }
+	encoded, err := json.Marshal(struct{ ID *fields.QualifiedHash }{reply.ID()})
+	if err != nil {
+		t.Fatalf("Failed to marshal ID to JSON: %v", err)
+	}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Errorf("Failed to unmarshal ID from JSON: %v", err)
+	} else if !decoded.ID.Equals(reply.ID()) {
+		t.Errorf("ID changed during JSON round trip, expected %v, got %v", reply.ID(), decoded.ID)
+	}
+}
+
+func TestParseQualifiedHashRejectsInvalid(t *testing.T) {
+	_, _, _, reply := MakeReplyOrSkip(t)
+	valid, err := reply.ID().MarshalString()
+	if err != nil {
+		t.Fatalf("Failed to marshal ID: %v", err)
+	}
+	invalid := []string{
+		"",
+		"SHA512",
+		"SHA512_B32",
+		"SHA512_B32__",                       // wrong length
+		"SHA512_B032__" + valid[12:],         // leading zero
+		"SHA512_B-32__" + valid[12:],         // sign
+		"MD5_B32__" + valid[12:],             // unknown type
+		"SHA512_B16__AAAAAAAAAAAAAAAAAAAAAA", // invalid length for type
+		valid + "=",                          // padding
+		valid[:len(valid)-1] + "!",           // not base64url
+	}
+	for _, text := range invalid {
+		if _, err := fields.ParseQualifiedHash(text); err == nil {
+			t.Errorf("ParseQualifiedHash(%q) should fail", text)
+		}
+	}
+	var content fields.QualifiedContent
+	for _, text := range []string{"UTF-8_B5hell", "UTF-8_B5hello!", "UTF-8_Bhello", "UTF-8_B05hello", "Unknown_B5hello"} {
+		if err := content.UnmarshalText([]byte(text)); err == nil {
+			t.Errorf("QualifiedContent.UnmarshalText(%q) should fail", text)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package forest_test
+
+import (
+	"testing"
+	"time"
+
+	forest "git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+func TestNewNodesRecordCreationTime(t *testing.T) {
+	before := fields.TimestampFrom(time.Now())
+	identity, _, community, reply := MakeValidReplyOrSkip(t)
+	after := fields.TimestampFrom(time.Now())
+	s := forest.NewMemoryStore()
+	if err := s.Add(identity); err != nil {
+		t.Skip("Failed to add identity", err)
+	}
+	assertRoundTrips(t, s, identity, community, reply)
+	for _, created := range []fields.Timestamp{identity.Created, community.Created, reply.Created} {
+		if created < before || created > after {
+			t.Errorf("Expected creation time between %d and %d, got %d", before, after, created)
+		}
+	}
+	if reply.SchemaVersion != fields.VersionTimestamps {
+		t.Errorf("Expected new nodes to have version %d, got %d", fields.VersionTimestamps, reply.SchemaVersion)
+	}
+}
+
+func TestBuilderNow(t *testing.T) {
+	identity, signer := MakeValidIdentityOrSkip(t)
+	when := time.Date(2019, time.June, 1, 12, 0, 0, 0, time.UTC)
+	builder := forest.As(identity, signer)
+	builder.Now = func() time.Time { return when }
+	community, err := builder.NewCommunity(QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte("name")), QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}")))
+	if err != nil {
+		t.Fatalf("Failed to create community: %v", err)
+	}
+	if !community.Created.Time().Equal(when) {
+		t.Errorf("Expected creation time %v, got %v", when, community.Created.Time())
+	}
+}
+
+func TestVersionOneNodesStillDecode(t *testing.T) {
+	identity, signer, community, reply := MakeValidReplyOrSkip(t)
+	reply.SchemaVersion = fields.VersionInitial
+	reply.Created = 0
+	old := resignReplyOrSkip(t, reply, signer)
+	if old.SchemaVersion != fields.VersionInitial || old.Created != 0 {
+		t.Errorf("Expected version 1 reply without creation time, got version %d created %d", old.SchemaVersion, old.Created)
+	}
+	s := forest.NewValidatingStore(forest.NewMemoryStore())
+	for _, node := range []forest.Node{identity, community, old} {
+		if err := s.Add(node); err != nil {
+			t.Errorf("Version 1 reply should be valid, got %v", err)
+		}
+	}
+}
+
+func TestValidateTimestamps(t *testing.T) {
+	identity, signer, community, reply := MakeValidReplyOrSkip(t)
+	s := forest.NewValidatingStore(forest.NewMemoryStore())
+	for _, node := range []forest.Node{identity, community} {
+		if err := s.Add(node); err != nil {
+			t.Skip("Failed to add node", err)
+		}
+	}
+	reply.Created = 0
+	expectValidationFailure(t, s.Add(resignReplyOrSkip(t, reply, signer)), forest.CheckShallow)
+
+	// a child may be slightly older than its parent, but not by more than the skew
+	reply.Created = fields.TimestampFrom(community.Created.Time().Add(-forest.MaxTimestampSkew / 2))
+	if err := s.Add(resignReplyOrSkip(t, reply, signer)); err != nil {
+		t.Errorf("Reply within the allowed skew should be valid, got %v", err)
+	}
+	reply.Created = fields.TimestampFrom(community.Created.Time().Add(-2 * forest.MaxTimestampSkew))
+	expectValidationFailure(t, s.Add(resignReplyOrSkip(t, reply, signer)), forest.CheckReferences)
+}
@@ -0,0 +1,128 @@
+package forest_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"testing"
+
+	forest "git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+func marshalNodesOrSkip(t *testing.T, nodes ...forest.Node) [][]byte {
+	out := make([][]byte, len(nodes))
+	for i, node := range nodes {
+		b, err := node.MarshalBinary()
+		if err != nil {
+			t.Skip("Failed to marshal node", err)
+		}
+		out[i] = b
+	}
+	return out
+}
+
+func TestUnmarshalBinaryNodeTruncated(t *testing.T) {
+	identity, _, community, reply := MakeReplyOrSkip(t)
+	for _, b := range marshalNodesOrSkip(t, identity, community, reply) {
+		for end := 0; end < len(b); end++ {
+			_, err := forest.UnmarshalBinaryNode(b[:end])
+			var unmarshalErr *fields.UnmarshalError
+			if err == nil {
+				t.Errorf("Unmarshaling %d of %d bytes should fail", end, len(b))
+			} else if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("Unmarshaling %d of %d bytes should fail with io.ErrUnexpectedEOF, got %v", end, len(b), err)
+			} else if !errors.As(err, &unmarshalErr) {
+				t.Errorf("Unmarshaling %d of %d bytes should fail with a *fields.UnmarshalError, got %T", end, len(b), err)
+			} else if unmarshalErr.Offset > end {
+				t.Errorf("Error offset %d is beyond the end of the input (%d bytes)", unmarshalErr.Offset, end)
+			}
+		}
+	}
+}
+
+func TestUnmarshalBinaryNodeRejectsTrailingData(t *testing.T) {
+	identity, _, community, reply := MakeReplyOrSkip(t)
+	for _, b := range marshalNodesOrSkip(t, identity, community, reply) {
+		_, err := forest.UnmarshalBinaryNode(append(b, 0))
+		var trailingErr *forest.TrailingDataError
+		if !errors.As(err, &trailingErr) {
+			t.Errorf("Unmarshaling node with trailing data should fail with *forest.TrailingDataError, got %v", err)
+		} else if trailingErr.Consumed != len(b) || trailingErr.Length != len(b)+1 {
+			t.Errorf("Expected %d of %d bytes consumed, got %d of %d", len(b), len(b)+1, trailingErr.Consumed, trailingErr.Length)
+		}
+	}
+}
+
+func TestUnmarshalErrorNamesField(t *testing.T) {
+	_, _, _, reply := MakeReplyOrSkip(t)
+	b := marshalNodesOrSkip(t, reply)[0]
+	// claim that the parent hash is longer than the rest of the node
+	parentLengthOffset := 8 + 1 + 1
+	b[parentLengthOffset] = 0xff
+	b[parentLengthOffset+1] = 0xff
+	_, err := forest.UnmarshalBinaryNode(b)
+	var unmarshalErr *fields.UnmarshalError
+	if !errors.As(err, &unmarshalErr) {
+		t.Fatalf("Expected a *fields.UnmarshalError, got %v", err)
+	}
+	if unmarshalErr.Field != "fields.QualifiedHash/fields.Blob" {
+		t.Errorf("Expected failure in the parent hash, got %q", unmarshalErr.Field)
+	}
+	if unmarshalErr.Offset != parentLengthOffset+2 {
+		t.Errorf("Expected failure at offset %d, got %d", parentLengthOffset+2, unmarshalErr.Offset)
+	}
+}
+
+// fuzzSeedNodes returns the binary form of nodes of each type.
+func fuzzSeedNodes(f *testing.F) [][]byte {
+	signer, err := forest.NewEd25519Signer(ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)))
+	if err != nil {
+		f.Fatal(err)
+	}
+	name, _ := fields.NewQualifiedContent(fields.ContentTypeUTF8String, []byte("fuzz"))
+	metadata, _ := fields.NewQualifiedContent(fields.ContentTypeJSON, []byte("{}"))
+	identity, err := forest.NewIdentity(signer, name, metadata)
+	if err != nil {
+		f.Fatal(err)
+	}
+	builder := forest.As(identity, signer)
+	community, err := builder.NewCommunity(name, metadata)
+	if err != nil {
+		f.Fatal(err)
+	}
+	reply, err := builder.NewReply(community, name, metadata)
+	if err != nil {
+		f.Fatal(err)
+	}
+	var seeds [][]byte
+	for _, node := range []forest.Node{identity, community, reply} {
+		b, err := node.MarshalBinary()
+		if err != nil {
+			f.Fatal(err)
+		}
+		seeds = append(seeds, b)
+	}
+	return seeds
+}
+
+func FuzzUnmarshalBinaryNode(f *testing.F) {
+	for _, seed := range fuzzSeedNodes(f) {
+		f.Add(seed)
+		f.Add(seed[:len(seed)/2])
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		node, err := forest.UnmarshalBinaryNode(b)
+		if err != nil {
+			return
+		}
+		out, err := node.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Failed to marshal unmarshaled node: %v", err)
+		}
+		if !bytes.Equal(b, out) {
+			t.Errorf("Node did not round trip, expected %x, got %x", b, out)
+		}
+	})
+}
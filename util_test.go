@@ -1,8 +1,10 @@
 package forest_test
 
 import (
+	"encoding/json"
 	"testing"
 
+	forest "git.sr.ht/~whereswaldon/forest-go"
 	"git.sr.ht/~whereswaldon/forest-go/fields"
 )
 
@@ -13,3 +15,34 @@ func QualifiedContentOrSkip(t *testing.T, contentType fields.ContentType, conten
 	}
 	return qContent
 }
+
+// assertRoundTrips checks that each node is unchanged by conversion to and
+// from its binary form and, unless store is nil, its JSON form. The store must
+// contain the author of each node so that its signature can be validated.
+func assertRoundTrips(t *testing.T, store forest.Store, nodes ...forest.Node) {
+	for _, node := range nodes {
+		b, err := node.MarshalBinary()
+		if err != nil {
+			t.Errorf("Failed to marshal %T: %v", node, err)
+			continue
+		}
+		if decoded, err := forest.UnmarshalBinaryNode(b); err != nil {
+			t.Errorf("Failed to unmarshal %T: %v", node, err)
+		} else if !decoded.Equals(node) {
+			t.Errorf("%T changed during binary round trip", node)
+		}
+		if store == nil {
+			continue
+		}
+		text, err := json.Marshal(node)
+		if err != nil {
+			t.Errorf("Failed to marshal %T to JSON: %v", node, err)
+			continue
+		}
+		if decoded, err := forest.UnmarshalJSONNode(text, store); err != nil {
+			t.Errorf("Failed to unmarshal %T from JSON: %v", node, err)
+		} else if !decoded.Equals(node) {
+			t.Errorf("%T changed during JSON round trip", node)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package forest
+
+import (
+	"fmt"
+
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+)
+
+// ValidationCheck identifies one of the checks that a node must pass before
+// it is accepted by a ValidatingStore.
+type ValidationCheck int
+
+const (
+	// CheckShallow is the internal validity check performed by ValidateShallow
+	CheckShallow ValidationCheck = iota
+	// CheckID ensures that the ID of the node matches its contents
+	CheckID
+	// CheckReferences ensures that every node referenced by the node is
+	// already known, as performed by ValidateDeep
+	CheckReferences
+	// CheckSignature ensures that the node was signed by its author
+	CheckSignature
+	// CheckAncestry ensures that a node is consistent with its parent, as
+	// performed by an AncestryValidator
+	CheckAncestry
+	// CheckPolicy ensures that a node is permitted by the Policy of a
+	// ValidatingStore
+	CheckPolicy
+)
+
+var validationCheckNames = map[ValidationCheck]string{
+	CheckShallow:    "shallow",
+	CheckID:         "ID",
+	CheckReferences: "reference",
+	CheckSignature:  "signature",
+	CheckAncestry:   "ancestry",
+	CheckPolicy:     "policy",
+}
+
+func (c ValidationCheck) String() string {
+	if name, ok := validationCheckNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown (%d)", int(c))
+}
+
+// ValidationError describes a node that failed validation and which of the
+// checks it failed.
+type ValidationError struct {
+	Check ValidationCheck
+	// ID is the ID of the node that failed validation
+	ID  *fields.QualifiedHash
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	id, _ := e.ID.MarshalString()
+	return fmt.Sprintf("Node %s failed %s validation: %v", id, e.Check, e.Err)
+}
+
+// Unwrap returns the underlying reason that validation failed.
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// signedNode is a node that can have its ID and signature validated.
+type signedNode interface {
+	Node
+	Hashable
+	SignatureValidator
+}
+
+// validateNode checks the internal validity of the node, its ID, the existence
+// of every node that it references within the store, and its signature. Any
+// failure is returned as a *ValidationError.
+func validateNode(node Node, store Store) error {
+	fail := func(check ValidationCheck, err error) error {
+		return &ValidationError{Check: check, ID: node.ID(), Err: err}
+	}
+	if err := node.ValidateShallow(); err != nil {
+		return fail(CheckShallow, err)
+	}
+	signed, ok := node.(signedNode)
+	if !ok {
+		return fail(CheckSignature, fmt.Errorf("Node of type %T cannot be validated", node))
+	}
+	if valid, err := ValidateID(signed, *node.ID()); err != nil {
+		return fail(CheckID, err)
+	} else if !valid {
+		return fail(CheckID, fmt.Errorf("ID does not match node contents"))
+	}
+	if err := node.ValidateDeep(store); err != nil {
+		return fail(CheckReferences, err)
+	}
+	var author *Identity
+	if signed.IsIdentity() {
+		author, ok = node.(*Identity)
+		if !ok {
+			return fail(CheckSignature, fmt.Errorf("Node of type %T claims to be an identity", node))
+		}
+	} else {
+		authorNode, has, err := store.Get(signed.SignatureIdentityHash())
+		if err != nil {
+			return fail(CheckReferences, err)
+		} else if !has {
+			return fail(CheckReferences, fmt.Errorf("Missing author node %v", signed.SignatureIdentityHash()))
+		}
+		author, ok = authorNode.(*Identity)
+		if !ok {
+			return fail(CheckSignature, fmt.Errorf("Author must be an identity, got %T", authorNode))
+		}
+	}
+	if valid, err := validateSignatureIn(store, signed, author); err != nil {
+		return fail(CheckSignature, err)
+	} else if !valid {
+		return fail(CheckSignature, fmt.Errorf("Signature is invalid"))
+	}
+	return nil
+}
+
+// ValidatingStore wraps another Store and rejects any node that is invalid.
+// Before a node is added, it must pass ValidateShallow, have an ID that
+// matches its contents, reference only nodes that are already present in the
+// wrapped store, and be signed by its author. If the wrapped store is a
+// QueryStore, the author's key is chosen from its KeyHistory, so nodes signed
+// with rotated keys are accepted and nodes signed with revoked keys are not.
+// Nodes that fail any of these checks are rejected with a *ValidationError.
+//
+// Because referenced nodes must already be present, nodes must be added
+// after their parents and authors.
+//
+// If Policy is not nil, nodes must also be allowed by it, like a
+// CommunityPolicy that rejects replies by banned identities.
+type ValidatingStore struct {
+	Store
+	Policy Policy
+}
+
+// Policy decides whether a node that is otherwise valid may be added to a
+// ValidatingStore.
+type Policy interface {
+	// Allow returns an error explaining why the node may not be added.
+	Allow(node Node) error
+}
+
+// NewValidatingStore wraps the given store so that only valid nodes can be
+// added to it.
+func NewValidatingStore(store Store) *ValidatingStore {
+	return &ValidatingStore{Store: store}
+}
+
+// Add validates the node and inserts it into the wrapped store if it is valid.
+func (v *ValidatingStore) Add(node Node) error {
+	if err := validateNode(node, v.Store); err != nil {
+		return err
+	}
+	if v.Policy != nil {
+		if err := v.Policy.Allow(node); err != nil {
+			return &ValidationError{Check: CheckPolicy, ID: node.ID(), Err: err}
+		}
+	}
+	return v.Store.Add(node)
+}
+
+// Children returns the IDs of all known children of the node with the given
+// ID. The wrapped Store must be a ChildStore.
+func (v *ValidatingStore) Children(id *fields.QualifiedHash) ([]*fields.QualifiedHash, error) {
+	store, ok := v.Store.(ChildStore)
+	if !ok {
+		return nil, fmt.Errorf("Wrapped store %T does not support Children", v.Store)
+	}
+	return store.Children(id)
+}
+
+// Query returns a single page of the nodes matching the query. The wrapped
+// Store must be a QueryStore.
+func (v *ValidatingStore) Query(q *Query) (*QueryPage, error) {
+	store, ok := v.Store.(QueryStore)
+	if !ok {
+		return nil, fmt.Errorf("Wrapped store %T does not support Query", v.Store)
+	}
+	return store.Query(q)
+}
+
+// Iterate invokes fn once for each node within the store. The wrapped Store
+// must be an IterableStore.
+func (v *ValidatingStore) Iterate(fn func(Node) error) error {
+	store, ok := v.Store.(IterableStore)
+	if !ok {
+		return fmt.Errorf("Wrapped store %T does not support Iterate", v.Store)
+	}
+	return store.Iterate(fn)
+}
@@ -0,0 +1,106 @@
+package forest_test
+
+import (
+	"errors"
+	"testing"
+
+	forest "git.sr.ht/~whereswaldon/forest-go"
+	"git.sr.ht/~whereswaldon/forest-go/fields"
+	"golang.org/x/crypto/openpgp"
+)
+
+// MakeValidIdentityOrSkip creates an identity that passes ValidateShallow.
+func MakeValidIdentityOrSkip(t *testing.T) (*forest.Identity, forest.Signer) {
+	privkey, err := openpgp.NewEntity("forest-test", "comment", "email@email.io", nil)
+	if err != nil {
+		t.Skip("Failed to create private key", err)
+	}
+	signer, err := forest.NewNativeSigner(privkey)
+	if err != nil {
+		t.Skip("Failed to create signer", err)
+	}
+	username := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte("Test Name"))
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	identity, err := forest.NewIdentity(signer, username, metadata)
+	if err != nil {
+		t.Skip("Failed to create Identity with valid parameters", err)
+	}
+	return identity, signer
+}
+
+// MakeValidReplyOrSkip creates a community and reply that pass ValidateShallow.
+func MakeValidReplyOrSkip(t *testing.T) (*forest.Identity, forest.Signer, *forest.Community, *forest.Reply) {
+	identity, signer := MakeValidIdentityOrSkip(t)
+	name := QualifiedContentOrSkip(t, fields.ContentTypeUTF8String, []byte("Test Community"))
+	metadata := QualifiedContentOrSkip(t, fields.ContentTypeJSON, []byte("{}"))
+	community, err := forest.As(identity, signer).NewCommunity(name, metadata)
+	if err != nil {
+		t.Skip("Failed to create Community with valid parameters", err)
+	}
+	reply := newReplyOrSkip(t, forest.As(identity, signer), community, "test content")
+	return identity, signer, community, reply
+}
+
+func expectValidationFailure(t *testing.T, err error, check forest.ValidationCheck) {
+	var validationErr *forest.ValidationError
+	if err == nil {
+		t.Errorf("Expected %s validation to fail", check)
+	} else if !errors.As(err, &validationErr) {
+		t.Errorf("Expected a *ValidationError, got %T: %v", err, err)
+	} else if validationErr.Check != check {
+		t.Errorf("Expected %s validation to fail, got %v", check, err)
+	}
+}
+
+func TestValidatingStoreAcceptsValidNodes(t *testing.T) {
+	identity, _, community, reply := MakeValidReplyOrSkip(t)
+	s := forest.NewValidatingStore(forest.NewMemoryStore())
+	for _, node := range []forest.Node{identity, community, reply} {
+		if err := s.Add(node); err != nil {
+			t.Errorf("ValidatingStore should accept valid node %v, got %v", node.ID(), err)
+		}
+	}
+	if size, err := s.Size(); err != nil || size != 3 {
+		t.Errorf("ValidatingStore should contain 3 nodes, got %d (err: %v)", size, err)
+	}
+}
+
+func TestValidatingStoreRejectsMissingReferences(t *testing.T) {
+	identity, _, _, reply := MakeValidReplyOrSkip(t)
+	s := forest.NewValidatingStore(forest.NewMemoryStore())
+	if err := s.Add(identity); err != nil {
+		t.Skip("Failed to add identity", err)
+	}
+	expectValidationFailure(t, s.Add(reply), forest.CheckReferences)
+}
+
+func TestValidatingStoreRejectsInvalidShallow(t *testing.T) {
+	identity, _ := MakeIdentityOrSkip(t) // has non-JSON metadata
+	s := forest.NewValidatingStore(forest.NewMemoryStore())
+	expectValidationFailure(t, s.Add(identity), forest.CheckShallow)
+}
+
+func TestValidatingStoreRejectsTamperedNodes(t *testing.T) {
+	identity, _, community, reply := MakeValidReplyOrSkip(t)
+	s := forest.NewValidatingStore(forest.NewMemoryStore())
+	for _, node := range []forest.Node{identity, community} {
+		if err := s.Add(node); err != nil {
+			t.Skip("Failed to add node", err)
+		}
+	}
+	reply.Content.Blob = fields.Blob([]byte("TEST CONTENT"))
+	expectValidationFailure(t, s.Add(reply), forest.CheckID)
+}
+
+func TestValidatingStoreRejectsForgedSignatures(t *testing.T) {
+	identity, _, community, _ := MakeValidReplyOrSkip(t)
+	_, otherSigner := MakeValidIdentityOrSkip(t)
+	s := forest.NewValidatingStore(forest.NewMemoryStore())
+	for _, node := range []forest.Node{identity, community} {
+		if err := s.Add(node); err != nil {
+			t.Skip("Failed to add node", err)
+		}
+	}
+	forged := newReplyOrSkip(t, forest.As(identity, otherSigner), community, "forged")
+	expectValidationFailure(t, s.Add(forged), forest.CheckSignature)
+}